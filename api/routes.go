@@ -1,6 +1,10 @@
 package api
 
 import (
+	"log"
+	"strings"
+
+	"infra-manager/config"
 	"infra-manager/consts"
 	"infra-manager/controllers"
 	"infra-manager/middlewares"
@@ -18,6 +22,16 @@ var SERVICE_NAME = consts.SERVICE_NAME
 func SetupRouter() *gin.Engine {
 	r := gin.Default()
 
+	// 設定信任的反向代理，只有來自這些位址的請求才會採用 X-Forwarded-For 等標頭判斷用戶端 IP
+	if err := r.SetTrustedProxies(trustedProxies()); err != nil {
+		log.Fatalf("TRUSTED_PROXIES 設定無效: %v", err)
+	}
+	// 代理轉送給後端的 X-Forwarded-* 標頭同樣只沿用信任的代理送來的值
+	if err := services.SetTrustedProxies(trustedProxies()); err != nil {
+		log.Fatalf("TRUSTED_PROXIES 設定無效: %v", err)
+	}
+	r.RemoteIPHeaders = splitList(config.String("CLIENT_IP_HEADERS", "X-Forwarded-For,X-Real-IP"))
+
 	// 設置 Session 存儲
 	store := cookie.NewStore([]byte("infra_manager_secret"))
 	store.Options(sessions.Options{
@@ -42,6 +56,9 @@ func SetupRouter() *gin.Engine {
 	r.POST("/auth/login", controllers.Login)
 	r.GET("/logout", controllers.Logout)
 
+	// 後端驗證身分 JWT 用的公鑰
+	r.GET("/.well-known/jwks.json", controllers.GetJWKS)
+
 	// 主頁重定向到儀表板（如果已登入）或登入頁（如果未登入）
 	r.GET("/", func(c *gin.Context) {
 		session := sessions.Default(c)
@@ -102,6 +119,20 @@ func SetupRouter() *gin.Engine {
 		admin.PUT("/services/:id", controllers.UpdateService)
 		admin.DELETE("/services/:id", controllers.DeleteService)
 		admin.PATCH("/services/:id/status", controllers.ToggleServiceStatus)
+		admin.GET("/services/:id/health", controllers.GetServiceHealth)
+
+		// 服務的後端憑證（值不會回傳）
+		admin.GET("/services/:id/secrets", controllers.GetServiceSecrets)
+		admin.POST("/services/:id/secrets", controllers.CreateServiceSecret)
+		admin.PUT("/services/:id/secrets/:secret_id", controllers.UpdateServiceSecret)
+		admin.POST("/services/:id/secrets/:secret_id/rotate", controllers.RotateServiceSecret)
+		admin.DELETE("/services/:id/secrets/:secret_id", controllers.DeleteServiceSecret)
+
+		// 服務連線後端的 TLS 憑證（加密保存，內容不會回傳）
+		admin.PUT("/services/:id/tls/ca", controllers.UploadServiceCA)
+		admin.DELETE("/services/:id/tls/ca", controllers.DeleteServiceCA)
+		admin.PUT("/services/:id/tls/client-cert", controllers.UploadServiceClientCert)
+		admin.DELETE("/services/:id/tls/client-cert", controllers.DeleteServiceClientCert)
 
 		// Token管理
 		admin.GET("/tokens", controllers.GetAllTokens)
@@ -113,6 +144,35 @@ func SetupRouter() *gin.Engine {
 		admin.DELETE("/tokens/:id", controllers.DeleteToken)
 		admin.PATCH("/tokens/:id/status", controllers.ToggleTokenStatus)
 
+		// 用量配額
+		admin.GET("/quotas/:scope/:id", controllers.GetQuotaStatus)
+		admin.POST("/quotas/:scope/:id/top-ups", controllers.CreateQuotaTopUp)
+
+		// 系統執行狀態
+		systemRoutes := admin.Group("/system")
+		{
+			// 驗證快取
+			systemRoutes.GET("/auth-cache", controllers.GetAuthCacheStats)
+			systemRoutes.DELETE("/auth-cache", controllers.PurgeAuthCache)
+
+			// 存取紀錄寫入器
+			systemRoutes.GET("/access-log-writer", controllers.GetAccessLogWriterStats)
+
+			// 後端連線池
+			systemRoutes.GET("/transports", controllers.GetTransportStats)
+
+			// 熔斷器
+			systemRoutes.GET("/circuit-breakers", controllers.GetCircuitBreakers)
+			systemRoutes.DELETE("/circuit-breakers/:service_id", controllers.ResetCircuitBreaker)
+
+			// 回應快取
+			systemRoutes.GET("/response-cache", controllers.GetResponseCacheStats)
+			systemRoutes.DELETE("/response-cache", controllers.PurgeResponseCache)
+
+			// 更換 SERVICE_SECRET_KEY 後重新加密服務憑證
+			systemRoutes.POST("/service-secrets/re-encrypt", controllers.ReencryptServiceSecrets)
+		}
+
 		// 統計數據相關路由
 		statsRoutes := admin.Group("/stats")
 		{
@@ -122,6 +182,7 @@ func SetupRouter() *gin.Engine {
 
 			// 服務相關統計
 			statsRoutes.GET("/services/:service_id/time", controllers.GetServiceTimeStats)
+			statsRoutes.GET("/services/:service_id/mirror", controllers.GetServiceMirrorStats)
 
 			// 使用者相關統計
 			statsRoutes.GET("/users/services", controllers.GetUserServiceStats)
@@ -141,11 +202,27 @@ func SetupRouter() *gin.Engine {
 	// API代理路由 - 使用TokenAuth中間件處理
 	// 主要路由移至 /use/*，但保留 /api/* 作為相容備援
 	serviceGroupUse := r.Group("/use")
-	serviceGroupUse.Any("/*path", middlewares.NoIndex(), middlewares.TokenAuth(), middlewares.Logger(), services.ProxyRequest)
+	serviceGroupUse.Any("/*path", middlewares.NoIndex(), middlewares.Logger(), middlewares.TokenAuth(), middlewares.RateLimit(), services.ProxyRequest)
 
 	// 保留舊的 /api/* 路徑以便相容舊有的客戶端
 	serviceGroupOld := r.Group("/api")
-	serviceGroupOld.Any("/*path", middlewares.NoIndex(), middlewares.TokenAuth(), middlewares.Logger(), services.ProxyRequest)
+	serviceGroupOld.Any("/*path", middlewares.NoIndex(), middlewares.Logger(), middlewares.TokenAuth(), middlewares.RateLimit(), services.ProxyRequest)
 
 	return r
 }
+
+// trustedProxies 讀取 TRUSTED_PROXIES（以逗號分隔的 IP 或 CIDR）。
+// 未設定時不信任任何代理，用戶端 IP 一律取自連線的來源位址。
+func trustedProxies() []string {
+	return splitList(config.String("TRUSTED_PROXIES", ""))
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
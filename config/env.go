@@ -0,0 +1,45 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Int 讀取整數型環境變數，未設定或格式錯誤時回傳預設值
+func Int(name string, def int) int {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		fmt.Printf("警告: 環境變數 %s 的值 %q 無效，使用預設值 %d\n", name, value, def)
+		return def
+	}
+	return n
+}
+
+// Duration 讀取時間長度型環境變數（例如 30s、5m），未設定或格式錯誤時回傳預設值
+func Duration(name string, def time.Duration) time.Duration {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		fmt.Printf("警告: 環境變數 %s 的值 %q 無效，使用預設值 %s\n", name, value, def)
+		return def
+	}
+	return d
+}
+
+// String 讀取字串型環境變數，未設定時回傳預設值
+func String(name string, def string) string {
+	if value := strings.TrimSpace(os.Getenv(name)); value != "" {
+		return value
+	}
+	return def
+}
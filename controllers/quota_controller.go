@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"infra-manager/db"
+	"infra-manager/middlewares"
+	"infra-manager/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// validateQuota 檢查配額設定。週期只接受 daily、monthly（空白表示不啟用），
+// 避免拼錯的週期讓配額在沒有錯誤的情況下停用
+func validateQuota(quota models.Quota) error {
+	switch quota.Period {
+	case "", models.QuotaPeriodDaily, models.QuotaPeriodMonthly:
+	default:
+		return errors.New("無效的配額週期，可用值：daily、monthly")
+	}
+	if quota.MaxRequests < 0 || quota.MaxBytes < 0 {
+		return errors.New("配額的請求數與傳輸量上限不可為負數")
+	}
+	return nil
+}
+
+// 依範圍（token、user、service）讀取其配額設定
+func loadQuota(scope, id string) (uint, models.Quota, bool) {
+	switch scope {
+	case middlewares.QuotaScopeToken:
+		var token models.Token
+		if err := db.DB.First(&token, id).Error; err != nil {
+			return 0, models.Quota{}, false
+		}
+		return token.ID, token.Quota, true
+	case middlewares.QuotaScopeUser:
+		var user models.User
+		if err := db.DB.First(&user, id).Error; err != nil {
+			return 0, models.Quota{}, false
+		}
+		return user.ID, user.Quota, true
+	case middlewares.QuotaScopeService:
+		var service models.Service
+		if err := db.DB.First(&service, id).Error; err != nil {
+			return 0, models.Quota{}, false
+		}
+		return service.ID, service.Quota, true
+	}
+	return 0, models.Quota{}, false
+}
+
+// 獲取目前週期的剩餘配額
+func GetQuotaStatus(c *gin.Context) {
+	scopeID, quota, ok := loadQuota(c.Param("scope"), c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "找不到配額對象"})
+		return
+	}
+
+	status, err := middlewares.GetQuotaStatus(c.Param("scope"), scopeID, quota)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法獲取配額資訊", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+// 為目前週期加值一次性的配額
+func CreateQuotaTopUp(c *gin.Context) {
+	var topUpRequest struct {
+		Requests int64  `json:"requests"`
+		Bytes    int64  `json:"bytes"`
+		Note     string `json:"note"`
+	}
+
+	if err := c.ShouldBindJSON(&topUpRequest); err != nil || topUpRequest.Requests < 0 || topUpRequest.Bytes < 0 ||
+		(topUpRequest.Requests == 0 && topUpRequest.Bytes == 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無效的資料格式"})
+		return
+	}
+
+	scopeID, quota, ok := loadQuota(c.Param("scope"), c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "找不到配額對象"})
+		return
+	}
+
+	status, err := middlewares.AddQuotaTopUp(c.Param("scope"), scopeID, quota, topUpRequest.Requests, topUpRequest.Bytes, topUpRequest.Note)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "配額加值失敗", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, status)
+}
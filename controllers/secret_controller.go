@@ -0,0 +1,273 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"infra-manager/db"
+	"infra-manager/models"
+	"infra-manager/secrets"
+	"infra-manager/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 獲取服務的所有後端憑證（不含值）
+func GetServiceSecrets(c *gin.Context) {
+	service, ok := loadSecretService(c)
+	if !ok {
+		return
+	}
+
+	var serviceSecrets []models.ServiceSecret
+	if err := db.DB.Where("service_id = ?", service.ID).Order("id").Find(&serviceSecrets).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法獲取服務憑證"})
+		return
+	}
+
+	c.JSON(http.StatusOK, serviceSecrets)
+}
+
+// 新增服務的後端憑證
+func CreateServiceSecret(c *gin.Context) {
+	service, ok := loadSecretService(c)
+	if !ok {
+		return
+	}
+
+	var secretRequest struct {
+		Name      string `json:"name"`
+		Placement string `json:"placement"`
+		Key       string `json:"key"`
+		Value     string `json:"value"`
+	}
+	if err := c.ShouldBindJSON(&secretRequest); err != nil || strings.TrimSpace(secretRequest.Name) == "" || secretRequest.Value == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無效的資料格式"})
+		return
+	}
+	if err := validateSecretTarget(secretRequest.Placement, secretRequest.Key, secretRequest.Value); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	secretRequest.Name = strings.TrimSpace(secretRequest.Name)
+
+	var count int64
+	db.DB.Model(&models.ServiceSecret{}).Where("service_id = ? AND name = ?", service.ID, secretRequest.Name).Count(&count)
+	if count > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "憑證名稱已存在"})
+		return
+	}
+
+	secret := models.ServiceSecret{
+		ServiceID: service.ID,
+		Name:      secretRequest.Name,
+		Placement: secretRequest.Placement,
+		Key:       secretKeyFor(secretRequest.Placement, secretRequest.Key),
+		Version:   1,
+	}
+	ciphertext, err := secrets.EncryptSecret(secretRequest.Value, secret.EncryptionContext())
+	if err != nil {
+		respondSecretError(c, err)
+		return
+	}
+	secret.Ciphertext = ciphertext
+
+	if err := db.DB.Create(&secret).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "新增服務憑證失敗"})
+		return
+	}
+
+	services.InvalidateCredentials(service.ID)
+
+	c.JSON(http.StatusCreated, secret)
+}
+
+// 更新後端憑證注入的標頭或查詢參數名稱（不變更值，變更值請使用輪替；變更注入方式請重新建立）
+func UpdateServiceSecret(c *gin.Context) {
+	secret, ok := loadServiceSecret(c)
+	if !ok {
+		return
+	}
+
+	var secretRequest struct {
+		Key string `json:"key"`
+	}
+	if err := c.ShouldBindJSON(&secretRequest); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無效的資料格式"})
+		return
+	}
+	if secret.Placement == models.SecretPlacementBasicAuth {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "basic_auth 憑證沒有可變更的名稱"})
+		return
+	}
+	if err := validateSecretTarget(secret.Placement, secretRequest.Key, ""); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := db.DB.Model(&secret).Update("key", secretKeyFor(secret.Placement, secretRequest.Key)).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新服務憑證失敗"})
+		return
+	}
+
+	services.InvalidateCredentials(secret.ServiceID)
+
+	c.JSON(http.StatusOK, secret)
+}
+
+// 輪替後端憑證的值，下一個代理請求立即使用新值
+func RotateServiceSecret(c *gin.Context) {
+	secret, ok := loadServiceSecret(c)
+	if !ok {
+		return
+	}
+
+	var rotateRequest struct {
+		Value string `json:"value"`
+	}
+	if err := c.ShouldBindJSON(&rotateRequest); err != nil || rotateRequest.Value == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無效的資料格式"})
+		return
+	}
+	if err := validateSecretTarget(secret.Placement, secret.Key, rotateRequest.Value); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ciphertext, err := secrets.EncryptSecret(rotateRequest.Value, secret.EncryptionContext())
+	if err != nil {
+		respondSecretError(c, err)
+		return
+	}
+
+	now := time.Now()
+	if err := db.DB.Model(&secret).Updates(models.ServiceSecret{
+		Ciphertext: ciphertext,
+		Version:    secret.Version + 1,
+		RotatedAt:  &now,
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新服務憑證失敗"})
+		return
+	}
+
+	services.InvalidateCredentials(secret.ServiceID)
+
+	c.JSON(http.StatusOK, secret)
+}
+
+// 刪除後端憑證（直接刪除，不保留密文）
+func DeleteServiceSecret(c *gin.Context) {
+	secret, ok := loadServiceSecret(c)
+	if !ok {
+		return
+	}
+
+	if err := db.DB.Unscoped().Delete(&secret).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "刪除服務憑證失敗"})
+		return
+	}
+
+	services.InvalidateCredentials(secret.ServiceID)
+
+	c.JSON(http.StatusOK, gin.H{"message": "服務憑證已刪除"})
+}
+
+// 以目前的 SERVICE_SECRET_KEY 重新加密所有以舊金鑰加密的憑證
+func ReencryptServiceSecrets(c *gin.Context) {
+	count, err := services.ReencryptServiceSecrets()
+	if err != nil {
+		respondSecretError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "服務憑證已重新加密", "reencrypted": count})
+}
+
+func loadSecretService(c *gin.Context) (models.Service, bool) {
+	var service models.Service
+	if err := db.DB.First(&service, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "找不到服務"})
+		return service, false
+	}
+	return service, true
+}
+
+func loadServiceSecret(c *gin.Context) (models.ServiceSecret, bool) {
+	var secret models.ServiceSecret
+	if err := db.DB.Where("service_id = ?", c.Param("id")).First(&secret, c.Param("secret_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "找不到服務憑證"})
+		return secret, false
+	}
+	return secret, true
+}
+
+// validateSecretTarget 檢查注入方式與名稱；value 為空白時不檢查值
+func validateSecretTarget(placement, key, value string) error {
+	switch placement {
+	case models.SecretPlacementHeader:
+		if !validHeaderName(key) {
+			return errors.New("無效的標頭名稱")
+		}
+		if isReservedSecretHeader(key) {
+			return errors.New("此標頭由代理管理，不可作為憑證注入")
+		}
+		if strings.ContainsAny(value, "\r\n\x00") {
+			return errors.New("標頭值包含無效字元")
+		}
+	case models.SecretPlacementQuery:
+		if strings.TrimSpace(key) == "" {
+			return errors.New("請指定查詢參數名稱")
+		}
+	case models.SecretPlacementBasicAuth:
+		if value != "" && !strings.Contains(value, ":") {
+			return errors.New("basic_auth 的值必須是 username:password")
+		}
+	default:
+		return errors.New("無效的注入方式，可用值：header、query、basic_auth")
+	}
+	return nil
+}
+
+// validHeaderName 檢查標頭名稱是否只包含 RFC 9110 的 token 字元
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("!#$%&'*+-.^_`|~", r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// isReservedSecretHeader 判斷標頭是否由代理本身設定（逐跳、轉送與身分標頭）
+func isReservedSecretHeader(name string) bool {
+	name = http.CanonicalHeaderKey(name)
+	if strings.HasPrefix(name, services.IdentityHeaderPrefix) || strings.HasPrefix(name, "X-Forwarded-") {
+		return true
+	}
+	switch name {
+	case "Host", "Connection", "Upgrade", "Transfer-Encoding", "Content-Length", "Te", "Trailer", "Keep-Alive", "Forwarded":
+		return true
+	}
+	return false
+}
+
+// secretKeyFor 回傳要保存的名稱，basic_auth 不使用名稱
+func secretKeyFor(placement, key string) string {
+	if placement == models.SecretPlacementBasicAuth {
+		return ""
+	}
+	return strings.TrimSpace(key)
+}
+
+func respondSecretError(c *gin.Context, err error) {
+	if errors.Is(err, secrets.ErrSecretKeyMissing) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "服務憑證加密失敗", "details": err.Error()})
+}
@@ -1,11 +1,16 @@
 package controllers
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"infra-manager/db"
+	"infra-manager/middlewares"
 	"infra-manager/models"
+	"infra-manager/services"
 
 	"github.com/gin-gonic/gin"
 )
@@ -43,6 +48,68 @@ func CreateService(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "無效的資料格式"})
 		return
 	}
+	if err := validateRateLimit(service.RateLimit); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateQuota(service.Quota); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateIPLists(service.IPAllowlist, service.IPDenylist); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := services.ValidateUpstreams(service.LoadBalancing, service.Upstreams); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := services.ValidateRoutes(service.Routes); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !validForwardHeaders(service.ForwardHeaders) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無效的轉送標頭設定"})
+		return
+	}
+	if !validIdentity(service.Identity) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無效的身分傳遞設定"})
+		return
+	}
+	if !validRewriteBody(service.RewriteBody) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無效的 body 改寫設定"})
+		return
+	}
+	if err := validateResponseCache(service.Cache); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !validBodyLimits(service.BodyLimits) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "body 大小上限不可為負數"})
+		return
+	}
+	if !validTransport(service.Transport) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "h2c 與 disable_http2 不可同時啟用"})
+		return
+	}
+	if !validStreaming(service.Streaming) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "串流設定不可為負數"})
+		return
+	}
+	if err := validateUpstreamTLS(service.TLS); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := services.ValidateMirror(service.Mirror); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// 憑證只能經由上傳 API 設定
+	service.TLS = models.UpstreamTLS{
+		ServerName:         service.TLS.ServerName,
+		MinVersion:         service.TLS.MinVersion,
+		InsecureSkipVerify: service.TLS.InsecureSkipVerify,
+	}
 
 	result := db.DB.Create(&service)
 	if result.Error != nil {
@@ -50,6 +117,9 @@ func CreateService(c *gin.Context) {
 		return
 	}
 
+	// 清除驗證快取
+	middlewares.InvalidateAuthCache()
+
 	c.JSON(http.StatusCreated, service)
 }
 
@@ -64,28 +134,240 @@ func UpdateService(c *gin.Context) {
 	}
 
 	var updatedService struct {
-		Name        string `json:"name"`
-		Description string `json:"description"`
-		BaseURL     string `json:"base_url"`
-		IsActive    bool   `json:"is_active"`
+		Name            string                 `json:"name"`
+		Description     string                 `json:"description"`
+		BaseURL         string                 `json:"base_url"`
+		IsActive        bool                   `json:"is_active"`
+		TokenSources    string                 `json:"token_sources"`
+		TokenQueryParam string                 `json:"token_query_param"`
+		RateLimit       *models.RateLimit      `json:"rate_limit"`
+		Quota           *models.Quota          `json:"quota"`
+		IPAllowlist     *[]string              `json:"ip_allowlist"`
+		IPDenylist      *[]string              `json:"ip_denylist"`
+		Upstreams       *[]models.Upstream     `json:"upstreams"`
+		LoadBalancing   string                 `json:"load_balancing"`
+		Routes          *[]models.RouteRule    `json:"routes"`
+		ForwardHeaders  string                 `json:"forward_headers"`
+		Identity        *string                `json:"identity"`
+		WebApp          *bool                  `json:"web_app"`
+		RewriteBody     *string                `json:"rewrite_body"`
+		HealthCheck     *models.HealthCheck    `json:"health_check"`
+		Timeouts        *models.Timeouts       `json:"timeouts"`
+		Retry           *models.RetryPolicy    `json:"retry"`
+		CircuitBreaker  *models.CircuitBreaker `json:"circuit_breaker"`
+		Transport       *models.Transport      `json:"transport"`
+		Cache           *models.ResponseCache  `json:"cache"`
+		BodyLimits      *models.BodyLimits     `json:"body_limits"`
+		Streaming       *models.Streaming      `json:"streaming"`
+		TLS             *models.UpstreamTLS    `json:"tls"`
+		Mirror          *models.Mirror         `json:"mirror"`
 	}
 
 	if err := c.ShouldBindJSON(&updatedService); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "無效的資料格式"})
 		return
 	}
+	if updatedService.RateLimit != nil {
+		if err := validateRateLimit(*updatedService.RateLimit); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if updatedService.Quota != nil {
+		if err := validateQuota(*updatedService.Quota); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if err := validateIPListPointers(updatedService.IPAllowlist, updatedService.IPDenylist); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var upstreams []models.Upstream
+	if updatedService.Upstreams != nil {
+		upstreams = *updatedService.Upstreams
+	}
+	if err := services.ValidateUpstreams(updatedService.LoadBalancing, upstreams); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if updatedService.Routes != nil {
+		if err := services.ValidateRoutes(*updatedService.Routes); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if !validForwardHeaders(updatedService.ForwardHeaders) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無效的轉送標頭設定"})
+		return
+	}
+	if updatedService.Identity != nil && !validIdentity(*updatedService.Identity) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無效的身分傳遞設定"})
+		return
+	}
+	if updatedService.RewriteBody != nil && !validRewriteBody(*updatedService.RewriteBody) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無效的 body 改寫設定"})
+		return
+	}
+	if updatedService.Cache != nil {
+		if err := validateResponseCache(*updatedService.Cache); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if updatedService.BodyLimits != nil && !validBodyLimits(*updatedService.BodyLimits) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "body 大小上限不可為負數"})
+		return
+	}
+	if updatedService.Transport != nil && !validTransport(*updatedService.Transport) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "h2c 與 disable_http2 不可同時啟用"})
+		return
+	}
+	if updatedService.Streaming != nil && !validStreaming(*updatedService.Streaming) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "串流設定不可為負數"})
+		return
+	}
+	if updatedService.TLS != nil {
+		if err := validateUpstreamTLS(*updatedService.TLS); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if updatedService.Mirror != nil {
+		if err := services.ValidateMirror(*updatedService.Mirror); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
 
 	// 更新服務資訊
 	db.DB.Model(&service).Updates(models.Service{
-		Name:        updatedService.Name,
-		Description: updatedService.Description,
-		BaseURL:     updatedService.BaseURL,
-		IsActive:    updatedService.IsActive,
+		Name:            updatedService.Name,
+		Description:     updatedService.Description,
+		BaseURL:         updatedService.BaseURL,
+		IsActive:        updatedService.IsActive,
+		TokenSources:    updatedService.TokenSources,
+		TokenQueryParam: updatedService.TokenQueryParam,
+		LoadBalancing:   updatedService.LoadBalancing,
+		ForwardHeaders:  updatedService.ForwardHeaders,
 	})
 
+	// 限流與配額設定允許設為 0（不限制），因此需明確指定欄位更新
+	if updatedService.RateLimit != nil {
+		db.DB.Model(&service).Select("rate_limit_requests", "rate_limit_per", "rate_limit_burst").
+			Updates(models.Service{RateLimit: *updatedService.RateLimit})
+	}
+	if updatedService.Quota != nil {
+		db.DB.Model(&service).Select("quota_period", "quota_max_requests", "quota_max_bytes").
+			Updates(models.Service{Quota: *updatedService.Quota})
+	}
+
+	// IP 清單允許清空，因此同樣明確指定欄位更新
+	if updatedService.IPAllowlist != nil {
+		db.DB.Model(&service).Select("ip_allowlist").Updates(models.Service{IPAllowlist: *updatedService.IPAllowlist})
+	}
+	if updatedService.IPDenylist != nil {
+		db.DB.Model(&service).Select("ip_denylist").Updates(models.Service{IPDenylist: *updatedService.IPDenylist})
+	}
+
+	// 身分傳遞設定為空白表示停用，因此需明確指定欄位更新
+	if updatedService.Identity != nil {
+		db.DB.Model(&service).Select("identity").Updates(models.Service{Identity: *updatedService.Identity})
+	}
+	if updatedService.WebApp != nil {
+		db.DB.Model(&service).Select("web_app").Updates(models.Service{WebApp: *updatedService.WebApp})
+	}
+	if updatedService.RewriteBody != nil {
+		db.DB.Model(&service).Select("rewrite_body").Updates(models.Service{RewriteBody: *updatedService.RewriteBody})
+	}
+
+	// 路由規則清空表示全部路徑原樣轉發
+	if updatedService.Routes != nil {
+		db.DB.Model(&service).Select("routes").Updates(models.Service{Routes: *updatedService.Routes})
+	}
+
+	// 後端目標清空時改用 BaseURL；健康檢查路徑清空表示停用
+	if updatedService.Upstreams != nil {
+		db.DB.Model(&service).Select("upstreams").Updates(models.Service{Upstreams: *updatedService.Upstreams})
+	}
+	if updatedService.HealthCheck != nil {
+		db.DB.Model(&service).Select("health_check_path", "health_check_interval_seconds", "health_check_timeout_seconds",
+			"health_check_healthy_threshold", "health_check_unhealthy_threshold").
+			Updates(models.Service{HealthCheck: *updatedService.HealthCheck})
+	}
+
+	// 逾時、重試與熔斷設定的 0 代表預設值或停用
+	if updatedService.Timeouts != nil {
+		db.DB.Model(&service).Select("timeout_connect_seconds", "timeout_response_header_seconds", "timeout_total_seconds").
+			Updates(models.Service{Timeouts: *updatedService.Timeouts})
+	}
+	if updatedService.Retry != nil {
+		db.DB.Model(&service).Select("retry_max_retries", "retry_backoff_ms", "retry_max_body_bytes").
+			Updates(models.Service{Retry: *updatedService.Retry})
+	}
+	if updatedService.CircuitBreaker != nil {
+		db.DB.Model(&service).Select("circuit_breaker_failure_threshold", "circuit_breaker_open_seconds", "circuit_breaker_half_open_requests").
+			Updates(models.Service{CircuitBreaker: *updatedService.CircuitBreaker})
+	}
+	if updatedService.Transport != nil {
+		db.DB.Model(&service).Select("transport_max_idle_conns", "transport_max_idle_conns_per_host", "transport_max_conns_per_host",
+			"transport_idle_conn_timeout_seconds", "transport_keep_alive_seconds", "transport_disable_http2", "transport_h2c").
+			Updates(models.Service{Transport: *updatedService.Transport})
+	}
+	if updatedService.Cache != nil {
+		db.DB.Model(&service).Select("cache_enabled", "cache_default_ttl_seconds", "cache_key_headers", "cache_max_entry_bytes").
+			Updates(models.Service{Cache: *updatedService.Cache})
+	}
+	if updatedService.BodyLimits != nil {
+		db.DB.Model(&service).Select("body_limit_max_request_bytes", "body_limit_max_buffered_response_bytes").
+			Updates(models.Service{BodyLimits: *updatedService.BodyLimits})
+	}
+	if updatedService.Streaming != nil {
+		db.DB.Model(&service).Select("stream_flush_interval_ms", "stream_keep_alive_seconds").
+			Updates(models.Service{Streaming: *updatedService.Streaming})
+	}
+	if updatedService.TLS != nil {
+		// 憑證只能經由上傳 API 設定，這裡只更新連線設定
+		db.DB.Model(&service).Select("tls_server_name", "tls_min_version", "tls_insecure_skip_verify").
+			Updates(models.Service{TLS: *updatedService.TLS})
+	}
+	if updatedService.Mirror != nil {
+		db.DB.Model(&service).Select("mirror_url", "mirror_sample_percent", "mirror_max_body_bytes", "mirror_timeout_seconds",
+			"mirror_include_credentials").
+			Updates(models.Service{Mirror: *updatedService.Mirror})
+	}
+
+	// 清除驗證快取，並以新的設定重建連線池
+	middlewares.InvalidateAuthCache()
+	services.InvalidateService(service.ID)
+
 	c.JSON(http.StatusOK, service)
 }
 
+// 獲取服務各後端目標的健康狀態
+func GetServiceHealth(c *gin.Context) {
+	id := c.Param("id")
+
+	var service models.Service
+	if err := db.DB.First(&service, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "找不到服務"})
+		return
+	}
+
+	targets, err := services.GetUpstreamHealth(service)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"service_id":           service.ID,
+		"load_balancing":       service.LoadBalancing,
+		"health_check_enabled": service.HealthCheck.Enabled(),
+		"targets":              targets,
+	})
+}
+
 // 刪除服務
 func DeleteService(c *gin.Context) {
 	id := c.Param("id")
@@ -109,6 +391,14 @@ func DeleteService(c *gin.Context) {
 		return
 	}
 
+	// 服務的後端憑證不再使用，直接刪除密文
+	db.DB.Unscoped().Where("service_id = ?", service.ID).Delete(&models.ServiceSecret{})
+
+	// 清除驗證快取並關閉連線池
+	middlewares.InvalidateAuthCache()
+	services.InvalidateService(service.ID)
+	services.InvalidateCredentials(service.ID)
+
 	c.JSON(http.StatusOK, gin.H{"message": "服務已刪除，該服務相關Token已標記為失效"})
 }
 
@@ -132,8 +422,112 @@ func ToggleServiceStatus(c *gin.Context) {
 	// 更新服務狀態
 	db.DB.Model(&service).Update("is_active", isActive)
 
+	// 清除驗證快取並關閉連線池
+	middlewares.InvalidateAuthCache()
+	services.InvalidateService(service.ID)
+
 	c.JSON(http.StatusOK, gin.H{
 		"message":   "服務狀態已更新",
 		"is_active": isActive,
 	})
 }
+
+// validForwardHeaders 檢查轉送標頭設定，空白表示使用預設值
+func validForwardHeaders(value string) bool {
+	switch value {
+	case "", models.ForwardHeadersXForwarded, models.ForwardHeadersForwarded, models.ForwardHeadersBoth, models.ForwardHeadersNone:
+		return true
+	}
+	return false
+}
+
+// validIdentity 檢查身分傳遞設定，空白表示不傳遞
+func validIdentity(value string) bool {
+	switch value {
+	case "", models.IdentityHeaders, models.IdentityJWT, models.IdentityBoth:
+		return true
+	}
+	return false
+}
+
+// validRewriteBody 檢查 body 改寫設定，空白表示不改寫
+func validRewriteBody(value string) bool {
+	switch value {
+	case "", models.RewriteBodyHTML, models.RewriteBodyHTMLCSS:
+		return true
+	}
+	return false
+}
+
+// validateRateLimit 檢查限流設定。週期只接受 second、minute（空白表示 second），
+// 避免拼錯的週期被當成每秒而讓限制放寬
+func validateRateLimit(limit models.RateLimit) error {
+	switch limit.Per {
+	case "", models.RateLimitPerSecond, models.RateLimitPerMinute:
+	default:
+		return errors.New("無效的限流週期，可用值：second、minute")
+	}
+	if limit.Requests < 0 || limit.Burst < 0 {
+		return errors.New("限流的請求數與突發請求數不可為負數")
+	}
+	return nil
+}
+
+// validateResponseCache 檢查回應快取設定
+func validateResponseCache(cache models.ResponseCache) error {
+	if cache.DefaultTTLSeconds < 0 || cache.MaxEntryBytes < 0 {
+		return errors.New("回應快取的預設快取時間與大小上限不可為負數")
+	}
+	for _, name := range cache.KeyHeaders {
+		if !validHeaderName(name) {
+			return fmt.Errorf("無效的快取鍵標頭名稱: %q", name)
+		}
+	}
+	return nil
+}
+
+// validBodyLimits 檢查 body 大小上限，0 表示不限制或使用預設值
+func validBodyLimits(limits models.BodyLimits) bool {
+	return limits.MaxRequestBytes >= 0 && limits.MaxBufferedResponseBytes >= 0
+}
+
+// validTransport 檢查連線池設定，h2c 需要 HTTP/2，不可與 disable_http2 同時啟用
+func validTransport(transport models.Transport) bool {
+	return !(transport.H2C && transport.DisableHTTP2)
+}
+
+// validStreaming 檢查串流設定，0 表示每次寫入即送出或不送 keep-alive
+func validStreaming(streaming models.Streaming) bool {
+	return streaming.FlushIntervalMs >= 0 && streaming.KeepAliveSeconds >= 0
+}
+
+// validateUpstreamTLS 檢查後端 TLS 的連線設定
+func validateUpstreamTLS(settings models.UpstreamTLS) error {
+	if !models.ValidTLSVersion(settings.MinVersion) {
+		return errors.New("無效的最低 TLS 版本，可用值：1.0、1.1、1.2、1.3")
+	}
+	if strings.ContainsAny(settings.ServerName, " /:\t\r\n") {
+		return errors.New("無效的 TLS 伺服器名稱")
+	}
+	return nil
+}
+
+// validateIPLists 檢查 IP 允許與拒絕清單的格式
+func validateIPLists(allowlist, denylist []string) error {
+	if err := middlewares.ValidateIPRules(allowlist); err != nil {
+		return err
+	}
+	return middlewares.ValidateIPRules(denylist)
+}
+
+// validateIPListPointers 檢查更新請求中有提供的 IP 清單
+func validateIPListPointers(allowlist, denylist *[]string) error {
+	var allow, deny []string
+	if allowlist != nil {
+		allow = *allowlist
+	}
+	if denylist != nil {
+		deny = *denylist
+	}
+	return validateIPLists(allow, deny)
+}
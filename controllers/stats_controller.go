@@ -7,6 +7,7 @@ import (
 
 	"infra-manager/db"
 	"infra-manager/models"
+	"infra-manager/services"
 
 	"github.com/gin-gonic/gin"
 )
@@ -68,7 +69,7 @@ func GetUserTokenStats(c *gin.Context) {
 		UserID      uint   `json:"user_id"`
 		Username    string `json:"username"`
 		TokenID     uint   `json:"token_id"`
-		TokenValue  string `json:"token_value"`
+		TokenPrefix string `json:"token_prefix"`
 		ServiceID   uint   `json:"service_id"`
 		ServiceName string `json:"service_name"`
 		Count       int    `json:"count"`
@@ -83,7 +84,7 @@ func GetUserTokenStats(c *gin.Context) {
 			al.user_id, 
 			u.username,
 			al.token_id,
-			t.token_value,
+			t.token_prefix,
 			al.service_id, 
 			s.name AS service_name,
 			COUNT(*) AS count,
@@ -430,7 +431,7 @@ func GetUserTokenTimeStats(c *gin.Context) {
 		UserID      uint   `json:"user_id"`
 		Username    string `json:"username"`
 		TokenID     uint   `json:"token_id"`
-		TokenValue  string `json:"token_value"`
+		TokenPrefix string `json:"token_prefix"`
 		ServiceID   uint   `json:"service_id"`
 		ServiceName string `json:"service_name"`
 		Date        string `json:"date"`
@@ -446,7 +447,7 @@ func GetUserTokenTimeStats(c *gin.Context) {
 			al.user_id, 
 			u.username,
 			al.token_id,
-			t.token_value,
+			t.token_prefix,
 			al.service_id, 
 			s.name AS service_name,
 			DATE(al.created_at) AS date,
@@ -480,7 +481,7 @@ func GetUserTokenTimeStats(c *gin.Context) {
 				al.user_id, 
 				u.username,
 				al.token_id,
-				t.token_value,
+				t.token_prefix,
 				al.service_id, 
 				s.name AS service_name,
 				DATE(al.created_at) AS date,
@@ -508,3 +509,91 @@ func GetUserTokenTimeStats(c *gin.Context) {
 
 	c.JSON(http.StatusOK, stats)
 }
+
+// 獲取服務流量鏡像的比較統計
+func GetServiceMirrorStats(c *gin.Context) {
+	serviceID := c.Param("service_id")
+	days, err := strconv.Atoi(c.DefaultQuery("days", "7"))
+	if err != nil || days <= 0 {
+		days = 7
+	}
+	since := time.Now().AddDate(0, 0, -days)
+
+	type MirrorSummary struct {
+		Total              int     `json:"total"`
+		Sent               int     `json:"sent"`
+		Skipped            int     `json:"skipped"`
+		Errors             int     `json:"errors"`
+		StatusMismatches   int     `json:"status_mismatches"`
+		BodyMismatches     int     `json:"body_mismatches"`
+		AvgPrimaryDuration float64 `json:"avg_primary_duration"`
+		AvgMirrorDuration  float64 `json:"avg_mirror_duration"`
+		MaxMirrorDuration  int64   `json:"max_mirror_duration"`
+		TotalPrimaryBytes  int64   `json:"total_primary_bytes"`
+		TotalMirrorBytes   int64   `json:"total_mirror_bytes"`
+	}
+	type StatusPair struct {
+		PrimaryStatus int `json:"primary_status"`
+		MirrorStatus  int `json:"mirror_status"`
+		Count         int `json:"count"`
+	}
+
+	var summary MirrorSummary
+	// 未送出的鏡像（skipped_*）只計入 total 與 skipped；延遲只統計有回應的鏡像請求
+	result := db.DB.Raw(`
+		SELECT 
+			COUNT(*) AS total,
+			COALESCE(SUM(CASE WHEN error NOT LIKE 'skipped\_%' ESCAPE '\' THEN 1 ELSE 0 END), 0) AS sent,
+			COALESCE(SUM(CASE WHEN error LIKE 'skipped\_%' ESCAPE '\' THEN 1 ELSE 0 END), 0) AS skipped,
+			COALESCE(SUM(CASE WHEN error <> '' AND error NOT LIKE 'skipped\_%' ESCAPE '\' THEN 1 ELSE 0 END), 0) AS errors,
+			COALESCE(SUM(CASE WHEN error NOT LIKE 'skipped\_%' ESCAPE '\' AND NOT status_match THEN 1 ELSE 0 END), 0) AS status_mismatches,
+			COALESCE(SUM(CASE WHEN status_match AND NOT body_match THEN 1 ELSE 0 END), 0) AS body_mismatches,
+			COALESCE(AVG(CASE WHEN mirror_status > 0 THEN primary_duration END), 0) AS avg_primary_duration,
+			COALESCE(AVG(CASE WHEN mirror_status > 0 THEN mirror_duration END), 0) AS avg_mirror_duration,
+			COALESCE(MAX(mirror_duration), 0) AS max_mirror_duration,
+			COALESCE(SUM(primary_size), 0) AS total_primary_bytes,
+			COALESCE(SUM(mirror_size), 0) AS total_mirror_bytes
+		FROM 
+			mirror_logs
+		WHERE 
+			service_id = ? AND created_at >= ? AND deleted_at IS NULL
+	`, serviceID, since).Scan(&summary)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法獲取鏡像統計數據", "details": result.Error.Error()})
+		return
+	}
+
+	var statusPairs []StatusPair
+	result = db.DB.Raw(`
+		SELECT 
+			primary_status,
+			mirror_status,
+			COUNT(*) AS count
+		FROM 
+			mirror_logs
+		WHERE 
+			service_id = ? AND created_at >= ? AND deleted_at IS NULL AND error NOT LIKE 'skipped\_%' ESCAPE '\'
+		GROUP BY 
+			primary_status, mirror_status
+		ORDER BY 
+			count DESC
+	`, serviceID, since).Scan(&statusPairs)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法獲取鏡像統計數據", "details": result.Error.Error()})
+		return
+	}
+
+	// 最近狀態碼或 body 不一致的鏡像請求，方便比對差異
+	var mismatches []models.MirrorLog
+	db.DB.Where("service_id = ? AND created_at >= ? AND error NOT LIKE ? ESCAPE '\\' AND NOT (status_match AND body_match)", serviceID, since, `skipped\_%`).
+		Order("id DESC").Limit(20).Find(&mismatches)
+
+	c.JSON(http.StatusOK, gin.H{
+		"service_id":        serviceID,
+		"days":              days,
+		"summary":           summary,
+		"status_pairs":      statusPairs,
+		"recent_mismatches": mismatches,
+		"dropped":           services.MirrorDropped(), // 自啟動以來因同時進行的鏡像請求過多而捨棄的次數（所有服務合計）
+	})
+}
@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"infra-manager/db"
+	"infra-manager/middlewares"
+	"infra-manager/secrets"
+	"infra-manager/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 獲取驗證快取的命中統計
+func GetAuthCacheStats(c *gin.Context) {
+	c.JSON(http.StatusOK, middlewares.GetAuthCacheStats())
+}
+
+// 手動清空驗證快取
+func PurgeAuthCache(c *gin.Context) {
+	middlewares.InvalidateAuthCache()
+	c.JSON(http.StatusOK, gin.H{"message": "驗證快取已清空"})
+}
+
+// 獲取存取紀錄寫入器的佇列深度與捨棄數
+func GetAccessLogWriterStats(c *gin.Context) {
+	c.JSON(http.StatusOK, db.GetAccessLogWriterStats())
+}
+
+// 獲取各服務連線池的設定
+func GetTransportStats(c *gin.Context) {
+	c.JSON(http.StatusOK, services.GetTransportStats())
+}
+
+// 獲取各服務熔斷器的狀態
+func GetCircuitBreakers(c *gin.Context) {
+	c.JSON(http.StatusOK, services.GetCircuitBreakers())
+}
+
+// 手動將服務的熔斷器恢復為正常狀態
+func ResetCircuitBreaker(c *gin.Context) {
+	serviceID, err := strconv.ParseUint(c.Param("service_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無效的服務ID"})
+		return
+	}
+
+	if !services.ResetCircuitBreaker(uint(serviceID)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "找不到此服務的熔斷器"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "熔斷器已重置"})
+}
+
+// 獲取回應快取的大小與各服務的命中統計
+func GetResponseCacheStats(c *gin.Context) {
+	c.JSON(http.StatusOK, services.GetResponseCacheStats())
+}
+
+// 清除回應快取，可用 service_id 指定服務、prefix 指定路徑前綴（例如 /v1/items）
+func PurgeResponseCache(c *gin.Context) {
+	var serviceID uint64
+	if value := c.Query("service_id"); value != "" {
+		id, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || id == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "無效的服務ID"})
+			return
+		}
+		serviceID = id
+	}
+	prefix := c.Query("prefix")
+	if prefix != "" && serviceID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "依路徑前綴清除時需指定服務ID"})
+		return
+	}
+
+	purged := services.PurgeResponseCache(uint(serviceID), prefix)
+	c.JSON(http.StatusOK, gin.H{"message": "回應快取已清除", "purged": purged})
+}
+
+// 公開驗證身分 JWT 用的公鑰（JWKS），後端可依 kid 驗證 X-Infra-Identity 標頭
+func GetJWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, secrets.IdentityJWKS())
+}
@@ -0,0 +1,199 @@
+package controllers
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"infra-manager/db"
+	"infra-manager/middlewares"
+	"infra-manager/models"
+	"infra-manager/secrets"
+	"infra-manager/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 上傳服務連線後端使用的 CA 憑證（PEM，可包含多張），取代系統信任的 CA
+func UploadServiceCA(c *gin.Context) {
+	service, ok := loadSecretService(c)
+	if !ok {
+		return
+	}
+
+	var uploadRequest struct {
+		PEM string `json:"pem"`
+	}
+	if err := c.ShouldBindJSON(&uploadRequest); err != nil || strings.TrimSpace(uploadRequest.PEM) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無效的資料格式"})
+		return
+	}
+	expiresAt, err := parseCABundle(uploadRequest.PEM)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ciphertext, err := secrets.EncryptSecret(uploadRequest.PEM, models.TLSEncryptionContext(service.ID, models.TLSMaterialCA))
+	if err != nil {
+		respondSecretError(c, err)
+		return
+	}
+
+	service.TLS.CABundle = ciphertext
+	service.TLS.CAExpiresAt = &expiresAt
+	if err := db.DB.Model(&service).Select("tls_ca_bundle", "tls_ca_expires_at").Updates(models.Service{TLS: service.TLS}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存 CA 憑證失敗"})
+		return
+	}
+
+	// 代理使用驗證快取中的服務設定，需一併清除
+	middlewares.InvalidateAuthCache()
+	services.InvalidateService(service.ID)
+
+	c.JSON(http.StatusOK, service.TLS)
+}
+
+// 上傳服務連線後端使用的用戶端憑證與私鑰（PEM），用於 mTLS
+func UploadServiceClientCert(c *gin.Context) {
+	service, ok := loadSecretService(c)
+	if !ok {
+		return
+	}
+
+	var uploadRequest struct {
+		Cert string `json:"cert"`
+		Key  string `json:"key"`
+	}
+	if err := c.ShouldBindJSON(&uploadRequest); err != nil || strings.TrimSpace(uploadRequest.Cert) == "" || strings.TrimSpace(uploadRequest.Key) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無效的資料格式"})
+		return
+	}
+	leaf, err := parseClientCert(uploadRequest.Cert, uploadRequest.Key)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	certCiphertext, err := secrets.EncryptSecret(uploadRequest.Cert, models.TLSEncryptionContext(service.ID, models.TLSMaterialClientCert))
+	if err != nil {
+		respondSecretError(c, err)
+		return
+	}
+	keyCiphertext, err := secrets.EncryptSecret(uploadRequest.Key, models.TLSEncryptionContext(service.ID, models.TLSMaterialClientKey))
+	if err != nil {
+		respondSecretError(c, err)
+		return
+	}
+
+	service.TLS.ClientCert = certCiphertext
+	service.TLS.ClientKey = keyCiphertext
+	service.TLS.ClientCertSubject = leaf.Subject.String()
+	service.TLS.ClientCertExpiresAt = &leaf.NotAfter
+	err = db.DB.Model(&service).
+		Select("tls_client_cert", "tls_client_key", "tls_client_cert_subject", "tls_client_cert_expires_at").
+		Updates(models.Service{TLS: service.TLS}).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存用戶端憑證失敗"})
+		return
+	}
+
+	// 代理使用驗證快取中的服務設定，需一併清除
+	middlewares.InvalidateAuthCache()
+	services.InvalidateService(service.ID)
+
+	c.JSON(http.StatusOK, service.TLS)
+}
+
+// 移除服務的 CA 憑證，恢復使用系統信任的 CA
+func DeleteServiceCA(c *gin.Context) {
+	service, ok := loadSecretService(c)
+	if !ok {
+		return
+	}
+
+	service.TLS.CABundle = ""
+	service.TLS.CAExpiresAt = nil
+	if err := db.DB.Model(&service).Select("tls_ca_bundle", "tls_ca_expires_at").Updates(models.Service{TLS: service.TLS}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "移除 CA 憑證失敗"})
+		return
+	}
+
+	// 代理使用驗證快取中的服務設定，需一併清除
+	middlewares.InvalidateAuthCache()
+	services.InvalidateService(service.ID)
+
+	c.JSON(http.StatusOK, gin.H{"message": "CA 憑證已移除"})
+}
+
+// 移除服務的用戶端憑證與私鑰
+func DeleteServiceClientCert(c *gin.Context) {
+	service, ok := loadSecretService(c)
+	if !ok {
+		return
+	}
+
+	service.TLS.ClientCert = ""
+	service.TLS.ClientKey = ""
+	service.TLS.ClientCertSubject = ""
+	service.TLS.ClientCertExpiresAt = nil
+	err := db.DB.Model(&service).
+		Select("tls_client_cert", "tls_client_key", "tls_client_cert_subject", "tls_client_cert_expires_at").
+		Updates(models.Service{TLS: service.TLS}).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "移除用戶端憑證失敗"})
+		return
+	}
+
+	// 代理使用驗證快取中的服務設定，需一併清除
+	middlewares.InvalidateAuthCache()
+	services.InvalidateService(service.ID)
+
+	c.JSON(http.StatusOK, gin.H{"message": "用戶端憑證已移除"})
+}
+
+// parseCABundle 檢查 CA 憑證並回傳其中最早的到期時間
+func parseCABundle(bundle string) (time.Time, error) {
+	var expiresAt time.Time
+	rest := []byte(bundle)
+	count := 0
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return time.Time{}, errors.New("CA 憑證只能包含 CERTIFICATE 區塊")
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return time.Time{}, errors.New("無效的 CA 憑證: " + err.Error())
+		}
+		if count == 0 || cert.NotAfter.Before(expiresAt) {
+			expiresAt = cert.NotAfter
+		}
+		count++
+	}
+	if count == 0 {
+		return time.Time{}, errors.New("CA 憑證中沒有有效的 PEM 憑證")
+	}
+	return expiresAt, nil
+}
+
+// parseClientCert 檢查用戶端憑證與私鑰是否成對，回傳憑證本身
+func parseClientCert(certPEM, keyPEM string) (*x509.Certificate, error) {
+	pair, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+	if err != nil {
+		return nil, errors.New("無效的用戶端憑證或私鑰（私鑰需為未加密的 PEM）: " + err.Error())
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, errors.New("無效的用戶端憑證: " + err.Error())
+	}
+	return leaf, nil
+}
@@ -8,7 +8,9 @@ import (
 	"time"
 
 	"infra-manager/db"
+	"infra-manager/middlewares"
 	"infra-manager/models"
+	"infra-manager/secrets"
 
 	"github.com/gin-gonic/gin"
 )
@@ -118,11 +120,16 @@ func GetToken(c *gin.Context) {
 // 創建Token
 func CreateToken(c *gin.Context) {
 	var tokenRequest struct {
-		UserID      uint       `json:"user_id" binding:"required"`
-		ServiceID   uint       `json:"service_id" binding:"required"`
-		ExpiresAt   *time.Time `json:"expires_at"`
-		IsPermanent bool       `json:"is_permanent"`
-		Description string     `json:"description"` // 新增備註說明欄位
+		UserID      uint              `json:"user_id" binding:"required"`
+		ServiceID   uint              `json:"service_id" binding:"required"`
+		ExpiresAt   *time.Time        `json:"expires_at"`
+		IsPermanent bool              `json:"is_permanent"`
+		Description string            `json:"description"` // 新增備註說明欄位
+		RateLimit   models.RateLimit  `json:"rate_limit"`  // 覆寫服務的預設限流
+		Quota       models.Quota      `json:"quota"`
+		Scope       models.TokenScope `json:"scope"` // 允許存取的方法與路徑
+		IPAllowlist []string          `json:"ip_allowlist"`
+		IPDenylist  []string          `json:"ip_denylist"`
 	}
 
 	if err := c.ShouldBindJSON(&tokenRequest); err != nil {
@@ -130,6 +137,23 @@ func CreateToken(c *gin.Context) {
 		return
 	}
 
+	if err := middlewares.ValidateTokenScope(tokenRequest.Scope); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateRateLimit(tokenRequest.RateLimit); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateQuota(tokenRequest.Quota); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateIPLists(tokenRequest.IPAllowlist, tokenRequest.IPDenylist); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	// 檢查使用者是否存在且處於啟用狀態
 	var user models.User
 	if err := db.DB.Where("id = ? AND is_active = ?", tokenRequest.UserID, true).First(&user).Error; err != nil {
@@ -153,11 +177,17 @@ func CreateToken(c *gin.Context) {
 
 	// 創建Token記錄
 	token := models.Token{
-		TokenValue:  tokenValue,
+		TokenHash:   secrets.HashToken(tokenValue),
+		TokenPrefix: secrets.TokenPrefix(tokenValue),
 		UserID:      tokenRequest.UserID,
 		ServiceID:   tokenRequest.ServiceID,
 		IsActive:    true,
 		Description: tokenRequest.Description, // 設置備註說明
+		RateLimit:   tokenRequest.RateLimit,
+		Quota:       tokenRequest.Quota,
+		Scope:       tokenRequest.Scope,
+		IPAllowlist: tokenRequest.IPAllowlist,
+		IPDenylist:  tokenRequest.IPDenylist,
 	}
 
 	// 設置過期時間或永久有效
@@ -177,9 +207,15 @@ func CreateToken(c *gin.Context) {
 		return
 	}
 
+	// 清除驗證快取
+	middlewares.InvalidateAuthCache()
+
 	// 預載入關聯資訊
 	db.DB.Preload("User").Preload("Service").First(&token, token.ID)
 
+	// 明文Token僅在此回傳一次，資料庫只保存雜湊
+	token.TokenValue = tokenValue
+
 	c.JSON(http.StatusCreated, token)
 }
 
@@ -194,10 +230,15 @@ func UpdateToken(c *gin.Context) {
 	}
 
 	var updatedToken struct {
-		ExpiresAt   *time.Time `json:"expires_at"`
-		IsActive    bool       `json:"is_active"`
-		IsPermanent bool       `json:"is_permanent"`
-		Description string     `json:"description"` // 新增備註說明欄位
+		ExpiresAt   *time.Time         `json:"expires_at"`
+		IsActive    bool               `json:"is_active"`
+		IsPermanent bool               `json:"is_permanent"`
+		Description string             `json:"description"` // 新增備註說明欄位
+		RateLimit   *models.RateLimit  `json:"rate_limit"`
+		Quota       *models.Quota      `json:"quota"`
+		Scope       *models.TokenScope `json:"scope"`
+		IPAllowlist *[]string          `json:"ip_allowlist"`
+		IPDenylist  *[]string          `json:"ip_denylist"`
 	}
 
 	if err := c.ShouldBindJSON(&updatedToken); err != nil {
@@ -205,9 +246,47 @@ func UpdateToken(c *gin.Context) {
 		return
 	}
 
+	if updatedToken.Scope != nil {
+		if err := middlewares.ValidateTokenScope(*updatedToken.Scope); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if updatedToken.RateLimit != nil {
+		if err := validateRateLimit(*updatedToken.RateLimit); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if updatedToken.Quota != nil {
+		if err := validateQuota(*updatedToken.Quota); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if err := validateIPListPointers(updatedToken.IPAllowlist, updatedToken.IPDenylist); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	// 更新 IsActive 狀態與備註說明
 	token.IsActive = updatedToken.IsActive
 	token.Description = updatedToken.Description // 更新備註說明
+	if updatedToken.RateLimit != nil {
+		token.RateLimit = *updatedToken.RateLimit
+	}
+	if updatedToken.Quota != nil {
+		token.Quota = *updatedToken.Quota
+	}
+	if updatedToken.Scope != nil {
+		token.Scope = *updatedToken.Scope
+	}
+	if updatedToken.IPAllowlist != nil {
+		token.IPAllowlist = *updatedToken.IPAllowlist
+	}
+	if updatedToken.IPDenylist != nil {
+		token.IPDenylist = *updatedToken.IPDenylist
+	}
 
 	// 根據是否永久有效設置過期時間
 	if updatedToken.IsPermanent {
@@ -224,6 +303,9 @@ func UpdateToken(c *gin.Context) {
 		return
 	}
 
+	// 清除驗證快取
+	middlewares.InvalidateAuthCache()
+
 	// 重新載入關聯資訊
 	db.DB.Preload("User").Preload("Service").First(&token, token.ID)
 
@@ -245,6 +327,9 @@ func DeleteToken(c *gin.Context) {
 		return
 	}
 
+	// 清除驗證快取
+	middlewares.InvalidateAuthCache()
+
 	c.JSON(http.StatusOK, gin.H{"message": "Token已刪除"})
 }
 
@@ -274,6 +359,9 @@ func ToggleTokenStatus(c *gin.Context) {
 	// 更新Token狀態
 	db.DB.Model(&token).Update("is_active", isActive)
 
+	// 清除驗證快取
+	middlewares.InvalidateAuthCache()
+
 	c.JSON(http.StatusOK, gin.H{
 		"message":   "Token狀態已更新",
 		"is_active": isActive,
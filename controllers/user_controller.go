@@ -5,6 +5,7 @@ import (
 	"strconv"
 
 	"infra-manager/db"
+	"infra-manager/middlewares"
 	"infra-manager/models"
 
 	"github.com/gin-gonic/gin"
@@ -43,6 +44,14 @@ func CreateUser(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "無效的資料格式"})
 		return
 	}
+	if err := validateRateLimit(user.RateLimit); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateQuota(user.Quota); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	result := db.DB.Create(&user)
 	if result.Error != nil {
@@ -64,14 +73,28 @@ func UpdateUser(c *gin.Context) {
 	}
 
 	var updatedUser struct {
-		Username string `json:"username"`
-		IsActive bool   `json:"is_active"`
+		Username  string            `json:"username"`
+		IsActive  bool              `json:"is_active"`
+		RateLimit *models.RateLimit `json:"rate_limit"`
+		Quota     *models.Quota     `json:"quota"`
 	}
 
 	if err := c.ShouldBindJSON(&updatedUser); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "無效的資料格式"})
 		return
 	}
+	if updatedUser.RateLimit != nil {
+		if err := validateRateLimit(*updatedUser.RateLimit); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if updatedUser.Quota != nil {
+		if err := validateQuota(*updatedUser.Quota); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
 
 	// 更新使用者資訊
 	db.DB.Model(&user).Updates(models.User{
@@ -79,6 +102,19 @@ func UpdateUser(c *gin.Context) {
 		IsActive: updatedUser.IsActive,
 	})
 
+	// 限流與配額設定允許設為 0（不限制），因此需明確指定欄位更新
+	if updatedUser.RateLimit != nil {
+		db.DB.Model(&user).Select("rate_limit_requests", "rate_limit_per", "rate_limit_burst").
+			Updates(models.User{RateLimit: *updatedUser.RateLimit})
+	}
+	if updatedUser.Quota != nil {
+		db.DB.Model(&user).Select("quota_period", "quota_max_requests", "quota_max_bytes").
+			Updates(models.User{Quota: *updatedUser.Quota})
+	}
+
+	// 清除驗證快取
+	middlewares.InvalidateAuthCache()
+
 	c.JSON(http.StatusOK, user)
 }
 
@@ -105,6 +141,9 @@ func DeleteUser(c *gin.Context) {
 		return
 	}
 
+	// 清除驗證快取
+	middlewares.InvalidateAuthCache()
+
 	c.JSON(http.StatusOK, gin.H{"message": "使用者已刪除"})
 }
 
@@ -128,6 +167,9 @@ func ToggleUserStatus(c *gin.Context) {
 	// 更新使用者狀態
 	db.DB.Model(&user).Update("is_active", isActive)
 
+	// 清除驗證快取
+	middlewares.InvalidateAuthCache()
+
 	c.JSON(http.StatusOK, gin.H{
 		"message":   "使用者狀態已更新",
 		"is_active": isActive,
@@ -0,0 +1,206 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"infra-manager/config"
+	"infra-manager/models"
+)
+
+// 佇列已滿時的處理方式
+const (
+	AccessLogFullPolicyDrop  = "drop"  // 捨棄並計數
+	AccessLogFullPolicyBlock = "block" // 阻塞直到佇列有空間
+)
+
+// accessLogWriter 以背景 goroutine 批次寫入存取紀錄，避免每個代理請求都同步寫入 SQLite。
+// 佇列累積到 batchSize 筆或每隔 flushInterval 時，以單一交易寫入。
+type accessLogWriter struct {
+	queue         chan models.AccessLog
+	batchSize     int
+	flushInterval time.Duration
+	fullPolicy    string
+
+	// mu 保護 closed，確保 Stop 後不再送入已關閉的 channel
+	mu     sync.RWMutex
+	closed bool
+	done   chan struct{}
+
+	enqueued  atomic.Int64
+	written   atomic.Int64
+	dropped   atomic.Int64
+	failed    atomic.Int64
+	batches   atomic.Int64
+	lastFlush atomic.Int64 // UnixNano
+}
+
+// AccessLogWriterStats 是存取紀錄寫入器的統計資訊
+type AccessLogWriterStats struct {
+	Running       bool       `json:"running"`
+	QueueDepth    int        `json:"queue_depth"`
+	QueueCapacity int        `json:"queue_capacity"`
+	BatchSize     int        `json:"batch_size"`
+	FlushInterval string     `json:"flush_interval"`
+	FullPolicy    string     `json:"full_policy"`
+	Enqueued      int64      `json:"enqueued"`
+	Written       int64      `json:"written"`
+	Dropped       int64      `json:"dropped"`
+	Failed        int64      `json:"failed"`
+	Batches       int64      `json:"batches"`
+	LastFlushAt   *time.Time `json:"last_flush_at"`
+}
+
+var logWriter *accessLogWriter
+
+// StartAccessLogWriter 依環境變數設定啟動背景存取紀錄寫入器
+func StartAccessLogWriter() {
+	policy := strings.ToLower(config.String("ACCESS_LOG_FULL_POLICY", AccessLogFullPolicyDrop))
+	if policy != AccessLogFullPolicyDrop && policy != AccessLogFullPolicyBlock {
+		fmt.Printf("警告: ACCESS_LOG_FULL_POLICY 的值 %q 無效，使用 %s\n", policy, AccessLogFullPolicyDrop)
+		policy = AccessLogFullPolicyDrop
+	}
+
+	w := &accessLogWriter{
+		queue:         make(chan models.AccessLog, max(config.Int("ACCESS_LOG_QUEUE_SIZE", 10000), 1)),
+		batchSize:     max(config.Int("ACCESS_LOG_BATCH_SIZE", 100), 1),
+		flushInterval: config.Duration("ACCESS_LOG_FLUSH_INTERVAL", time.Second),
+		fullPolicy:    policy,
+		done:          make(chan struct{}),
+	}
+	if w.flushInterval <= 0 {
+		w.flushInterval = time.Second
+	}
+
+	logWriter = w
+	go w.run()
+}
+
+// StopAccessLogWriter 停止接收新紀錄，並等待佇列中的紀錄全部寫入或 ctx 逾時
+func StopAccessLogWriter(ctx context.Context) error {
+	w := logWriter
+	if w == nil {
+		return nil
+	}
+
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.mu.Unlock()
+
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// EnqueueAccessLog 將存取紀錄送入背景寫入佇列。寫入器未啟動或已停止時直接同步寫入。
+func EnqueueAccessLog(accessLog models.AccessLog) error {
+	w := logWriter
+	if w == nil {
+		return DB.Create(&accessLog).Error
+	}
+
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return DB.Create(&accessLog).Error
+	}
+
+	if w.fullPolicy == AccessLogFullPolicyBlock {
+		w.queue <- accessLog
+		w.enqueued.Add(1)
+		return nil
+	}
+
+	select {
+	case w.queue <- accessLog:
+		w.enqueued.Add(1)
+	default:
+		w.dropped.Add(1)
+	}
+	return nil
+}
+
+// GetAccessLogWriterStats 回傳存取紀錄寫入器的佇列深度與寫入統計
+func GetAccessLogWriterStats() AccessLogWriterStats {
+	w := logWriter
+	if w == nil {
+		return AccessLogWriterStats{}
+	}
+
+	w.mu.RLock()
+	running := !w.closed
+	w.mu.RUnlock()
+
+	stats := AccessLogWriterStats{
+		Running:       running,
+		QueueDepth:    len(w.queue),
+		QueueCapacity: cap(w.queue),
+		BatchSize:     w.batchSize,
+		FlushInterval: w.flushInterval.String(),
+		FullPolicy:    w.fullPolicy,
+		Enqueued:      w.enqueued.Load(),
+		Written:       w.written.Load(),
+		Dropped:       w.dropped.Load(),
+		Failed:        w.failed.Load(),
+		Batches:       w.batches.Load(),
+	}
+	if last := w.lastFlush.Load(); last > 0 {
+		t := time.Unix(0, last)
+		stats.LastFlushAt = &t
+	}
+	return stats
+}
+
+func (w *accessLogWriter) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]models.AccessLog, 0, w.batchSize)
+	for {
+		select {
+		case accessLog, ok := <-w.queue:
+			if !ok {
+				w.flush(batch)
+				return
+			}
+			batch = append(batch, accessLog)
+			if len(batch) >= w.batchSize {
+				w.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				w.flush(batch)
+				batch = batch[:0]
+			}
+		}
+	}
+}
+
+// flush 以單一交易寫入一批存取紀錄
+func (w *accessLogWriter) flush(batch []models.AccessLog) {
+	if len(batch) == 0 {
+		return
+	}
+
+	if err := DB.CreateInBatches(batch, w.batchSize).Error; err != nil {
+		w.failed.Add(int64(len(batch)))
+		fmt.Printf("寫入存取紀錄失敗（%d 筆）: %v\n", len(batch), err)
+	} else {
+		w.written.Add(int64(len(batch)))
+	}
+	w.batches.Add(1)
+	w.lastFlush.Store(time.Now().UnixNano())
+}
@@ -7,6 +7,7 @@ import (
 	"path"
 
 	"infra-manager/models"
+	"infra-manager/secrets"
 
 	"github.com/glebarez/sqlite"
 	"golang.org/x/crypto/bcrypt"
@@ -31,7 +32,12 @@ func InitDB() {
 	}
 
 	// 遷移資料庫結構
-	DB.AutoMigrate(&models.User{}, &models.Service{}, &models.Token{}, &models.AccessLog{}, &models.Admin{})
+	DB.AutoMigrate(&models.User{}, &models.Service{}, &models.Token{}, &models.AccessLog{}, &models.Admin{}, &models.QuotaGrant{}, &models.ServiceSecret{}, &models.MirrorLog{})
+
+	// 將舊版明文Token轉換為雜湊
+	if err := migrateTokenHashes(); err != nil {
+		log.Fatalf("Token雜湊遷移失敗: %v", err)
+	}
 
 	// 檢查並創建默認管理員
 	createDefaultAdmin()
@@ -77,3 +83,48 @@ func createDefaultAdmin() {
 		fmt.Println("已創建預設管理員帳號")
 	}
 }
+
+// 將舊版以明文儲存於 token_value 欄位的Token轉換為雜湊與顯示前綴，完成後移除 token_value 欄位。
+// 僅在資料表仍有 token_value 欄位時執行，因此只會執行一次。
+func migrateTokenHashes() error {
+	if !DB.Migrator().HasColumn(&models.Token{}, "token_value") {
+		return nil
+	}
+
+	type legacyToken struct {
+		ID         uint
+		TokenValue string
+	}
+	var legacyTokens []legacyToken
+	if err := DB.Table("tokens").Select("id, token_value").Find(&legacyTokens).Error; err != nil {
+		return err
+	}
+
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		for _, t := range legacyTokens {
+			if err := tx.Table("tokens").Where("id = ?", t.ID).Updates(map[string]interface{}{
+				"token_hash":   secrets.HashToken(t.TokenValue),
+				"token_prefix": secrets.TokenPrefix(t.TokenValue),
+			}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// 舊版欄位帶有 UNIQUE 約束，需先移除約束才能刪除欄位
+	if DB.Migrator().HasConstraint(&models.Token{}, "uni_tokens_token_value") {
+		if err := DB.Migrator().DropConstraint(&models.Token{}, "uni_tokens_token_value"); err != nil {
+			return err
+		}
+	}
+	if err := DB.Migrator().DropColumn(&models.Token{}, "token_value"); err != nil {
+		return err
+	}
+
+	fmt.Printf("已將 %d 個明文Token轉換為雜湊\n", len(legacyTokens))
+	return nil
+}
@@ -1,13 +1,20 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"infra-manager/api"
 	"infra-manager/db"
 	"infra-manager/models"
+	"infra-manager/services"
 )
 
 func main() {
@@ -15,9 +22,15 @@ func main() {
 	db.InitDB()
 
 	// 自動遷移資料庫結構，確保與模型一致
-	db.DB.AutoMigrate(&models.User{}, &models.Service{}, &models.Token{}, &models.AccessLog{}, &models.Admin{})
+	db.DB.AutoMigrate(&models.User{}, &models.Service{}, &models.Token{}, &models.AccessLog{}, &models.Admin{}, &models.QuotaGrant{}, &models.ServiceSecret{}, &models.MirrorLog{})
 	fmt.Println("資料庫結構已更新")
 
+	// 啟動背景存取紀錄寫入器
+	db.StartAccessLogWriter()
+
+	// 啟動後端目標健康檢查
+	services.StartHealthChecker()
+
 	// 設定埠號
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -26,7 +39,37 @@ func main() {
 
 	// 設置路由
 	router := api.SetupRouter()
+	// 未使用 TLS 時同樣接受 HTTP/2（h2c prior knowledge），讓 gRPC 用戶端可以直接連線
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetUnencryptedHTTP2(true)
+	server := &http.Server{
+		Addr:      ":" + port,
+		Handler:   router,
+		Protocols: protocols,
+	}
+
+	go func() {
+		fmt.Printf("基礎設施管理系統已啟動，監聽端口: %s\n", port)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
 
-	fmt.Printf("基礎設施管理系統已啟動，監聽端口: %s\n", port)
-	log.Fatal(router.Run(":" + port))
+	// 等待終止訊號後優雅關閉：先停止接收請求，再寫入剩餘的存取紀錄
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+	fmt.Println("正在關閉伺服器...")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		fmt.Printf("伺服器關閉失敗: %v\n", err)
+	}
+	services.StopHealthChecker()
+	if err := db.StopAccessLogWriter(shutdownCtx); err != nil {
+		fmt.Printf("存取紀錄寫入未完成: %v\n", err)
+	}
+	fmt.Println("伺服器已關閉")
 }
@@ -1,12 +1,16 @@
 package middlewares
 
 import (
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"infra-manager/db"
 	"infra-manager/models"
+	"infra-manager/secrets"
+	"infra-manager/urlpath"
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
@@ -68,65 +72,137 @@ func AdminLogin(username, password string, c *gin.Context) bool {
 	return true
 }
 
-// TokenAuth 是API的認證中間件
+// TokenAuth 是API的認證中間件。Token可由以下方式提供（實際允許的來源由服務設定決定）：
+//   - 路徑：/use/<service>/<token>/<endpoint>
+//   - 標頭：X-Infra-Token: <token> 或 Authorization: Bearer <token>，路徑為 /use/<service>/<endpoint>
+//   - 查詢參數：/use/<service>/<endpoint>?token=<token>
+//
+// 以標頭或查詢參數提供時，Token會在轉發前從請求中移除。
 func TokenAuth() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// 從api路徑獲取實際路徑
 		path := c.Param("path")
+		groupPrefix := strings.TrimSuffix(c.Request.URL.Path, path)
 		path = strings.TrimPrefix(path, "/")
 
-		parts := strings.SplitN(path, "/", 3)
-
-		if len(parts) < 2 {
+		parts := strings.SplitN(path, "/", 2)
+		serviceName := parts[0]
+		if serviceName == "" {
 			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "無效的API路徑"})
 			return
 		}
+		rest := ""
+		if len(parts) > 1 {
+			rest = parts[1]
+		}
 
-		serviceName := parts[0]
-		tokenValue := parts[1]
-
-		// 查詢服務
-		var service models.Service
-		if err := db.DB.Where("name = ? AND is_active = ?", serviceName, true).First(&service).Error; err != nil {
-			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "找不到服務"})
+		// 查詢服務（優先使用快取）
+		service, authErr := authCacheInstance.lookupService(serviceName)
+		if authErr != nil {
+			c.AbortWithStatusJSON(authErr.Status, gin.H{"error": authErr.Message})
 			return
 		}
 
-		// 查詢Token
-		var token models.Token
-		if err := db.DB.Where("token_value = ? AND service_id = ? AND is_active = ?", tokenValue, service.ID, true).First(&token).Error; err != nil {
-			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "無效的Token"})
+		// 優先從標頭或查詢參數取得Token，否則退回路徑中的Token
+		servicePrefix := strings.TrimSuffix(groupPrefix, "/") + "/" + serviceName
+		proxyPrefix := servicePrefix
+		logEndpoint := c.Request.URL.Path
+		targetEndpoint := rest
+		cred, ok := extractCredential(c.Request, service)
+		if !ok {
+			if !service.AllowsTokenSource(models.TokenSourcePath) {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "缺少Token"})
+				return
+			}
+			segments := strings.SplitN(rest, "/", 2)
+			if segments[0] == "" {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "缺少Token"})
+				return
+			}
+			cred = credential{Value: segments[0], Source: models.TokenSourcePath}
+			targetEndpoint = ""
+			if len(segments) > 1 {
+				targetEndpoint = segments[1]
+			}
+			proxyPrefix = servicePrefix + "/" + cred.Value
+			// 存取紀錄中僅保存Token前綴
+			logEndpoint = servicePrefix + "/" + secrets.TokenPrefix(cred.Value) + "***"
+			if len(segments) > 1 {
+				logEndpoint += "/" + targetEndpoint
+			}
+		}
+		stripCredential(c.Request, service, cred)
+
+		// 解析端點中的 . 與 ..，之後的存取範圍檢查與轉發都使用同一個路徑
+		targetEndpoint = urlpath.NormalizeEndpoint(targetEndpoint)
+
+		// 查詢Token與使用者（優先使用快取）
+		token, user, authErr := authCacheInstance.lookupToken(service.ID, secrets.HashToken(cred.Value))
+		if authErr != nil {
+			c.AbortWithStatusJSON(authErr.Status, gin.H{"error": authErr.Message})
 			return
 		}
 
+		// 儲存資訊到上下文；之後的檢查若拒絕請求，Logger 仍能記錄是哪個Token被拒絕
+		clientIP := c.ClientIP()
+		c.Set("token", token)
+		c.Set("service", service)
+		c.Set("user", user)
+		c.Set("targetEndpoint", targetEndpoint)
+		c.Set("tokenSource", cred.Source)
+		c.Set("proxyPrefix", proxyPrefix)
+		c.Set("logEndpoint", logEndpoint)
+		c.Set("clientIP", clientIP)
+
 		// 檢查Token是否過期 - 忽略 1000 年以上的過期時間 (視為永久有效)
 		farFuture := time.Now().AddDate(900, 0, 0) // 900年後
 		if token.ExpiresAt.Before(time.Now()) && token.ExpiresAt.Before(farFuture) {
+			c.Set("rejectReason", RejectTokenExpired)
 			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Token已過期"})
 			return
 		}
 
-		// 檢查用戶狀態
-		var user models.User
-		if err := db.DB.Where("id = ? AND is_active = ?", token.UserID, true).First(&user).Error; err != nil {
-			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "用戶已被停權"})
+		// 檢查來源 IP 是否在服務與Token的允許範圍內
+		if !ipAllowed(clientIP, service.IPAllowlist, service.IPDenylist) || !ipAllowed(clientIP, token.IPAllowlist, token.IPDenylist) {
+			c.Set("rejectReason", RejectIPDenied)
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "來源IP不允許存取", "client_ip": clientIP})
 			return
 		}
 
-		// 儲存資訊到上下文
-		c.Set("token", token)
-		c.Set("service", service)
-		c.Set("user", user)
-		c.Set("targetEndpoint", "")
-		if len(parts) > 2 {
-			c.Set("targetEndpoint", parts[2])
+		// 檢查Token的方法與路徑存取範圍
+		if reason, ok := checkTokenScope(token.Scope, c.Request.Method, targetEndpoint); !ok {
+			c.Set("rejectReason", RejectScopeDenied)
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "超出Token的存取範圍", "reason": reason})
+			return
+		}
+
+		// 檢查Token、使用者與服務的用量配額
+		exhausted, err := checkQuotas(token, user, service)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "配額查詢失敗"})
+			return
+		}
+		if exhausted != nil {
+			c.Set("rejectReason", RejectQuotaExceeded+"_"+exhausted.Scope)
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(time.Until(exhausted.ResetAt).Seconds()))))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+				"error":    "已超過用量配額",
+				"scope":    exhausted.Scope,
+				"period":   exhausted.Quota.Period,
+				"reset_at": exhausted.ResetAt,
+			})
+			return
 		}
 
 		c.Next()
 	}
 }
 
-// Logger 中間件記錄API存取日誌
+// Token已過期的拒絕原因
+const RejectTokenExpired = "token_expired"
+
+// Logger 中間件記錄API存取日誌，需放在 TokenAuth 之前，
+// 以便同時記錄已識別出Token但被後續檢查（過期、IP、存取範圍、配額、限流）拒絕的請求
 func Logger() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		startTime := time.Now()
@@ -134,7 +210,7 @@ func Logger() gin.HandlerFunc {
 		// 處理請求
 		c.Next()
 
-		// 不再過濾路徑前綴，所有通過 TokenAuth 的請求都會被記錄
+		// 不再過濾路徑前綴，所有被 TokenAuth 識別出Token的請求都會被記錄
 		// 獲取上下文中的資訊
 		tokenInterface, exists := c.Get("token")
 		if !exists {
@@ -149,18 +225,38 @@ func Logger() gin.HandlerFunc {
 
 		// 記錄存取日誌
 		accessLog := models.AccessLog{
-			UserID:       token.UserID,
-			TokenID:      token.ID,
-			ServiceID:    service.ID,
-			Endpoint:     c.Request.URL.Path,
-			Method:       c.Request.Method,
-			StatusCode:   c.Writer.Status(),
-			RequestSize:  c.Request.ContentLength,
-			ResponseSize: int64(c.Writer.Size()),
-			Duration:     duration,
-		}
-
-		if err := db.DB.Create(&accessLog).Error; err != nil {
+			UserID:         token.UserID,
+			TokenID:        token.ID,
+			ServiceID:      service.ID,
+			Endpoint:       c.GetString("logEndpoint"),
+			Method:         c.Request.Method,
+			StatusCode:     c.Writer.Status(),
+			RequestSize:    c.Request.ContentLength,
+			ResponseSize:   int64(c.Writer.Size()),
+			Duration:       duration,
+			RejectReason:   c.GetString("rejectReason"),
+			ClientIP:       c.GetString("clientIP"),
+			CacheStatus:    c.GetString("cacheStatus"),
+			GRPCStatus:     c.GetString("grpcStatus"),
+			StreamEvents:   c.GetInt64("streamEvents"),
+			StreamDuration: c.GetInt64("streamDuration"),
+		}
+
+		// 協定升級（如 WebSocket）的連線以雙向傳輸量記錄
+		if protocol := c.GetString("upgradeProtocol"); protocol != "" {
+			accessLog.Protocol = protocol
+			accessLog.RequestSize = c.GetInt64("tunnelBytesIn")
+			accessLog.ResponseSize = c.GetInt64("tunnelBytesOut")
+		}
+
+		// 成功轉發的請求計入用量配額
+		if accessLog.RejectReason == "" {
+			user := c.MustGet("user").(models.User)
+			recordQuotaUsage(token, user, service, max(accessLog.RequestSize, 0)+max(accessLog.ResponseSize, 0))
+		}
+
+		// 交由背景寫入器批次寫入，不阻塞請求
+		if err := db.EnqueueAccessLog(accessLog); err != nil {
 			// 僅記錄錯誤，不影響請求處理
 			c.Error(err)
 		}
@@ -0,0 +1,226 @@
+package middlewares
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"infra-manager/config"
+	"infra-manager/db"
+	"infra-manager/models"
+
+	"gorm.io/gorm"
+)
+
+// authError 描述驗證失敗時回傳給用戶端的狀態碼與訊息，也會被負向快取
+type authError struct {
+	Status  int
+	Message string
+}
+
+// errAuthLookupFailed 表示查詢資料庫時發生非「查無資料」的錯誤，此結果不會被快取
+var errAuthLookupFailed = &authError{Status: http.StatusInternalServerError, Message: "驗證資料查詢失敗"}
+
+type serviceCacheEntry struct {
+	service   models.Service
+	err       *authError
+	expiresAt time.Time
+}
+
+type tokenCacheEntry struct {
+	token     models.Token
+	user      models.User
+	err       *authError
+	expiresAt time.Time
+}
+
+// authCache 快取 TokenAuth 查詢到的服務、Token與使用者，減少代理請求的資料庫查詢。
+// 查無資料或已停權的結果也會以較短的 TTL 快取（負向快取）。
+// 管理介面修改相關資料時會呼叫 InvalidateAuthCache 立即清空快取。
+type authCache struct {
+	mu       sync.RWMutex
+	services map[string]serviceCacheEntry
+	tokens   map[string]tokenCacheEntry
+	// generation 在每次清空時遞增，避免清空前查到的舊資料在清空後才寫入快取
+	generation uint64
+
+	ttl         time.Duration
+	negativeTTL time.Duration
+	maxEntries  int
+
+	hits          atomic.Int64
+	misses        atomic.Int64
+	negativeHits  atomic.Int64
+	invalidations atomic.Int64
+}
+
+// AuthCacheStats 是驗證快取的統計資訊
+type AuthCacheStats struct {
+	Enabled       bool   `json:"enabled"`
+	TTL           string `json:"ttl"`
+	NegativeTTL   string `json:"negative_ttl"`
+	Entries       int    `json:"entries"`
+	Hits          int64  `json:"hits"`
+	Misses        int64  `json:"misses"`
+	NegativeHits  int64  `json:"negative_hits"`
+	Invalidations int64  `json:"invalidations"`
+}
+
+var authCacheInstance = newAuthCache(
+	config.Duration("AUTH_CACHE_TTL", 30*time.Second),
+	config.Duration("AUTH_CACHE_NEGATIVE_TTL", 5*time.Second),
+	config.Int("AUTH_CACHE_MAX_ENTRIES", 10000),
+)
+
+func newAuthCache(ttl, negativeTTL time.Duration, maxEntries int) *authCache {
+	return &authCache{
+		services:    make(map[string]serviceCacheEntry),
+		tokens:      make(map[string]tokenCacheEntry),
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		maxEntries:  maxEntries,
+	}
+}
+
+// InvalidateAuthCache 清空驗證快取，於管理介面修改使用者、服務或Token後呼叫
+func InvalidateAuthCache() {
+	cache := authCacheInstance
+	cache.mu.Lock()
+	cache.services = make(map[string]serviceCacheEntry)
+	cache.tokens = make(map[string]tokenCacheEntry)
+	cache.generation++
+	cache.mu.Unlock()
+	cache.invalidations.Add(1)
+}
+
+// GetAuthCacheStats 回傳驗證快取的命中統計
+func GetAuthCacheStats() AuthCacheStats {
+	cache := authCacheInstance
+	cache.mu.RLock()
+	entries := len(cache.services) + len(cache.tokens)
+	cache.mu.RUnlock()
+
+	return AuthCacheStats{
+		Enabled:       cache.ttl > 0,
+		TTL:           cache.ttl.String(),
+		NegativeTTL:   cache.negativeTTL.String(),
+		Entries:       entries,
+		Hits:          cache.hits.Load(),
+		Misses:        cache.misses.Load(),
+		NegativeHits:  cache.negativeHits.Load(),
+		Invalidations: cache.invalidations.Load(),
+	}
+}
+
+// lookupService 依名稱取得啟用中的服務
+func (a *authCache) lookupService(name string) (models.Service, *authError) {
+	now := time.Now()
+	a.mu.RLock()
+	entry, ok := a.services[name]
+	generation := a.generation
+	a.mu.RUnlock()
+	if ok && now.Before(entry.expiresAt) {
+		a.recordHit(entry.err)
+		return entry.service, entry.err
+	}
+	a.misses.Add(1)
+
+	entry = serviceCacheEntry{expiresAt: now.Add(a.ttl)}
+	if err := db.DB.Where("name = ? AND is_active = ?", name, true).First(&entry.service).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			// 資料庫暫時性錯誤不快取
+			return entry.service, errAuthLookupFailed
+		}
+		entry.err = &authError{Status: http.StatusNotFound, Message: "找不到服務"}
+		entry.expiresAt = now.Add(a.negativeTTL)
+	}
+
+	a.store(generation, func() { a.services[name] = entry })
+	return entry.service, entry.err
+}
+
+// lookupToken 依雜湊取得服務下啟用中的Token與其使用者
+func (a *authCache) lookupToken(serviceID uint, tokenHash string) (models.Token, models.User, *authError) {
+	key := tokenCacheKey(serviceID, tokenHash)
+	now := time.Now()
+	a.mu.RLock()
+	entry, ok := a.tokens[key]
+	generation := a.generation
+	a.mu.RUnlock()
+	if ok && now.Before(entry.expiresAt) {
+		a.recordHit(entry.err)
+		return entry.token, entry.user, entry.err
+	}
+	a.misses.Add(1)
+
+	entry = tokenCacheEntry{expiresAt: now.Add(a.ttl)}
+	if err := db.DB.Where("token_hash = ? AND service_id = ? AND is_active = ?", tokenHash, serviceID, true).First(&entry.token).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return entry.token, entry.user, errAuthLookupFailed
+		}
+		entry.err = &authError{Status: http.StatusForbidden, Message: "無效的Token"}
+	} else if err := db.DB.Where("id = ? AND is_active = ?", entry.token.UserID, true).First(&entry.user).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return entry.token, entry.user, errAuthLookupFailed
+		}
+		entry.err = &authError{Status: http.StatusForbidden, Message: "用戶已被停權"}
+	}
+	if entry.err != nil {
+		entry.expiresAt = now.Add(a.negativeTTL)
+	}
+
+	a.store(generation, func() { a.tokens[key] = entry })
+	return entry.token, entry.user, entry.err
+}
+
+// store 在快取啟用且查詢期間未被清空時寫入項目
+func (a *authCache) store(generation uint64, write func()) {
+	if a.ttl <= 0 {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.generation != generation {
+		return
+	}
+	a.makeRoom()
+	write()
+}
+
+func (a *authCache) recordHit(err *authError) {
+	a.hits.Add(1)
+	if err != nil {
+		a.negativeHits.Add(1)
+	}
+}
+
+// makeRoom 在快取已滿時清除過期項目，仍不足則整個清空。呼叫前需持有寫入鎖。
+func (a *authCache) makeRoom() {
+	if a.maxEntries <= 0 || len(a.services)+len(a.tokens) < a.maxEntries {
+		return
+	}
+
+	now := time.Now()
+	for key, entry := range a.services {
+		if !now.Before(entry.expiresAt) {
+			delete(a.services, key)
+		}
+	}
+	for key, entry := range a.tokens {
+		if !now.Before(entry.expiresAt) {
+			delete(a.tokens, key)
+		}
+	}
+
+	if len(a.services)+len(a.tokens) >= a.maxEntries {
+		a.services = make(map[string]serviceCacheEntry)
+		a.tokens = make(map[string]tokenCacheEntry)
+	}
+}
+
+func tokenCacheKey(serviceID uint, tokenHash string) string {
+	return strconv.FormatUint(uint64(serviceID), 10) + ":" + tokenHash
+}
@@ -0,0 +1,97 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"infra-manager/models"
+)
+
+// TokenHeader 是以自訂標頭傳遞Token時使用的標頭名稱
+const TokenHeader = "X-Infra-Token"
+
+// credential 描述從請求中取得的Token與其來源
+type credential struct {
+	Value  string
+	Source string
+}
+
+// extractCredential 依服務允許的來源，從標頭或查詢參數中取出Token。
+// 依序檢查 X-Infra-Token、Authorization: Bearer 與查詢參數；皆未提供時回傳 false，
+// 交由呼叫端改從路徑讀取。
+func extractCredential(r *http.Request, service models.Service) (credential, bool) {
+	if service.AllowsTokenSource(models.TokenSourceHeader) {
+		if value := strings.TrimSpace(r.Header.Get(TokenHeader)); value != "" {
+			return credential{Value: value, Source: models.TokenSourceHeader}, true
+		}
+	}
+
+	if service.AllowsTokenSource(models.TokenSourceBearer) {
+		if value := bearerToken(r); value != "" {
+			return credential{Value: value, Source: models.TokenSourceBearer}, true
+		}
+	}
+
+	if service.AllowsTokenSource(models.TokenSourceQuery) {
+		if value := r.URL.Query().Get(tokenQueryParam(service)); value != "" {
+			return credential{Value: value, Source: models.TokenSourceQuery}, true
+		}
+	}
+
+	return credential{}, false
+}
+
+// stripCredential 移除請求中用於驗證的Token，避免被轉發到後端服務。
+// X-Infra-Token 一律移除；Authorization 與查詢參數在作為憑證來源，或同時帶有相同的Token時移除，
+// 其餘（例如給後端使用的 Authorization）保留。
+func stripCredential(r *http.Request, service models.Service, cred credential) {
+	r.Header.Del(TokenHeader)
+
+	if cred.Source == models.TokenSourceBearer || bearerToken(r) == cred.Value {
+		r.Header.Del("Authorization")
+	}
+	param := tokenQueryParam(service)
+	if cred.Source == models.TokenSourceQuery || slices.Contains(r.URL.Query()[param], cred.Value) {
+		r.URL.RawQuery = removeQueryParam(r.URL.RawQuery, param)
+	}
+}
+
+// bearerToken 回傳 Authorization: Bearer 標頭中的Token，沒有時回傳空字串
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+// tokenQueryParam 回傳服務設定的Token查詢參數名稱，未設定時為 token
+func tokenQueryParam(service models.Service) string {
+	if service.TokenQueryParam == "" {
+		return "token"
+	}
+	return service.TokenQueryParam
+}
+
+// removeQueryParam 從原始查詢字串中移除指定參數，並保留其他參數的順序與編碼
+func removeQueryParam(rawQuery, name string) string {
+	if rawQuery == "" {
+		return rawQuery
+	}
+
+	pairs := strings.Split(rawQuery, "&")
+	kept := pairs[:0]
+	for _, pair := range pairs {
+		key := pair
+		if i := strings.IndexByte(pair, '='); i >= 0 {
+			key = pair[:i]
+		}
+		if decoded, err := url.QueryUnescape(key); err == nil && decoded == name {
+			continue
+		}
+		kept = append(kept, pair)
+	}
+	return strings.Join(kept, "&")
+}
@@ -0,0 +1,91 @@
+package middlewares
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"infra-manager/models"
+)
+
+func TestExtractAndStripCredential(t *testing.T) {
+	const token = "0123456789abcdef0123456789abcdef"
+	all := "path,header,bearer,query"
+
+	tests := []struct {
+		name       string
+		sources    string
+		queryParam string
+		target     string
+		header     map[string]string
+		wantSource string // 空白表示未從標頭或查詢參數取得，由 TokenAuth 改從路徑讀取
+		wantQuery  string // 移除Token後轉發的查詢字串
+		wantAuth   string // 移除Token後轉發的 Authorization
+	}{
+		{name: "路徑", sources: all, target: "/use/svc/" + token + "/items?a=1", wantQuery: "a=1"},
+		{name: "X-Infra-Token", sources: all, target: "/use/svc/items", header: map[string]string{TokenHeader: token}, wantSource: models.TokenSourceHeader},
+		{name: "Bearer", sources: all, target: "/use/svc/items", header: map[string]string{"Authorization": "Bearer " + token}, wantSource: models.TokenSourceBearer},
+		{name: "Bearer 不分大小寫", sources: all, target: "/use/svc/items", header: map[string]string{"Authorization": "bearer " + token}, wantSource: models.TokenSourceBearer},
+		{name: "查詢參數", sources: all, target: "/use/svc/items?a=1&token=" + token + "&b=%20", wantSource: models.TokenSourceQuery, wantQuery: "a=1&b=%20"},
+		{name: "自訂查詢參數名稱", sources: all, queryParam: "key", target: "/use/svc/items?key=" + token + "&token=keep", wantSource: models.TokenSourceQuery, wantQuery: "token=keep"},
+		{
+			name: "X-Infra-Token 優先於 Bearer 與查詢參數", sources: all, target: "/use/svc/items?token=" + token,
+			header:     map[string]string{TokenHeader: token, "Authorization": "Bearer " + token},
+			wantSource: models.TokenSourceHeader,
+		},
+		{
+			name: "Bearer 優先於查詢參數", sources: all, target: "/use/svc/items?token=" + token,
+			header:     map[string]string{"Authorization": "Bearer " + token},
+			wantSource: models.TokenSourceBearer,
+		},
+		{
+			name: "以 X-Infra-Token 驗證時保留給後端的 Authorization", sources: all, target: "/use/svc/items?token=other",
+			header:     map[string]string{TokenHeader: token, "Authorization": "Basic dXNlcjpwYXNz"},
+			wantSource: models.TokenSourceHeader, wantQuery: "token=other", wantAuth: "Basic dXNlcjpwYXNz",
+		},
+		{
+			name: "未允許的來源不讀取，但仍移除 X-Infra-Token", sources: "path", target: "/use/svc/" + token + "/items",
+			header:   map[string]string{TokenHeader: token, "Authorization": "Bearer upstream"},
+			wantAuth: "Bearer upstream",
+		},
+		{
+			name: "未設定來源時不讀取 Bearer 與查詢參數，但移除其中相同的Token", sources: "", target: "/use/svc/" + token + "/items?token=" + token,
+			header: map[string]string{"Authorization": "Bearer " + token},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := models.Service{TokenSources: tt.sources, TokenQueryParam: tt.queryParam}
+			r := httptest.NewRequest("GET", tt.target, nil)
+			for key, value := range tt.header {
+				r.Header.Set(key, value)
+			}
+
+			cred, ok := extractCredential(r, service)
+			if ok != (tt.wantSource != "") || cred.Source != tt.wantSource {
+				t.Fatalf("extractCredential 來源為 %q（%v），預期 %q", cred.Source, ok, tt.wantSource)
+			}
+			if !ok {
+				// 與 TokenAuth 相同改用路徑中的Token
+				cred = credential{Value: token, Source: models.TokenSourcePath}
+			} else if cred.Value != token {
+				t.Fatalf("extractCredential 取得 %q，預期 %q", cred.Value, token)
+			}
+
+			stripCredential(r, service, cred)
+			if r.Header.Get(TokenHeader) != "" {
+				t.Errorf("轉發的請求不應包含 %s", TokenHeader)
+			}
+			if got := r.Header.Get("Authorization"); got != tt.wantAuth {
+				t.Errorf("轉發的 Authorization 為 %q，預期 %q", got, tt.wantAuth)
+			}
+			if r.URL.RawQuery != tt.wantQuery {
+				t.Errorf("轉發的查詢字串為 %q，預期 %q", r.URL.RawQuery, tt.wantQuery)
+			}
+			if strings.Contains(r.URL.RawQuery, cred.Value) || strings.Contains(r.Header.Get("Authorization"), cred.Value) {
+				t.Errorf("轉發的請求仍包含Token: %q %v", r.URL.RawQuery, r.Header)
+			}
+		})
+	}
+}
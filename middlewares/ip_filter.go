@@ -0,0 +1,66 @@
+package middlewares
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// 來源 IP 不被允許的拒絕原因
+const RejectIPDenied = "ip_denied"
+
+// parseIPRule 將單一 IP 或 CIDR 解析為網段
+func parseIPRule(rule string) (*net.IPNet, error) {
+	rule = strings.TrimSpace(rule)
+	if strings.Contains(rule, "/") {
+		_, network, err := net.ParseCIDR(rule)
+		return network, err
+	}
+
+	ip := net.ParseIP(rule)
+	if ip == nil {
+		return nil, fmt.Errorf("無效的 IP 或 CIDR %q", rule)
+	}
+	bits := 128
+	if ip.To4() != nil {
+		ip = ip.To4()
+		bits = 32
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+// ValidateIPRules 檢查 IP 允許或拒絕清單中的每一項是否有效
+func ValidateIPRules(rules []string) error {
+	for _, rule := range rules {
+		if _, err := parseIPRule(rule); err != nil {
+			return fmt.Errorf("無效的 IP 或 CIDR %q", rule)
+		}
+	}
+	return nil
+}
+
+// ipAllowed 依拒絕清單與允許清單判斷 IP 是否可存取；允許清單為空表示不限制
+func ipAllowed(clientIP string, allowlist, denylist []string) bool {
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		return len(allowlist) == 0 && len(denylist) == 0
+	}
+
+	if ipMatchesAny(ip, denylist) {
+		return false
+	}
+	return len(allowlist) == 0 || ipMatchesAny(ip, allowlist)
+}
+
+func ipMatchesAny(ip net.IP, rules []string) bool {
+	for _, rule := range rules {
+		network, err := parseIPRule(rule)
+		if err != nil {
+			continue
+		}
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,286 @@
+package middlewares
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"infra-manager/config"
+	"infra-manager/db"
+	"infra-manager/models"
+)
+
+// 配額範圍
+const (
+	QuotaScopeToken   = "token"
+	QuotaScopeUser    = "user"
+	QuotaScopeService = "service"
+)
+
+// 配額用盡的拒絕原因前綴，完整原因為 quota_exceeded_<scope>
+const RejectQuotaExceeded = "quota_exceeded"
+
+// QuotaStatus 描述某個範圍在目前週期的配額使用情況
+type QuotaStatus struct {
+	Scope             string       `json:"scope"`
+	ScopeID           uint         `json:"scope_id"`
+	Quota             models.Quota `json:"quota"`
+	Enabled           bool         `json:"enabled"`
+	PeriodStart       time.Time    `json:"period_start"`
+	ResetAt           time.Time    `json:"reset_at"`
+	UsedRequests      int64        `json:"used_requests"`
+	UsedBytes         int64        `json:"used_bytes"`
+	GrantedRequests   int64        `json:"granted_requests"`
+	GrantedBytes      int64        `json:"granted_bytes"`
+	RemainingRequests *int64       `json:"remaining_requests"` // 未限制時為 null
+	RemainingBytes    *int64       `json:"remaining_bytes"`    // 未限制時為 null
+}
+
+// Exhausted 判斷配額是否已用盡
+func (s QuotaStatus) Exhausted() bool {
+	return (s.RemainingRequests != nil && *s.RemainingRequests <= 0) ||
+		(s.RemainingBytes != nil && *s.RemainingBytes <= 0)
+}
+
+// quotaCounter 是某個範圍在單一週期內的用量
+type quotaCounter struct {
+	periodStart     time.Time
+	periodEnd       time.Time
+	requests        int64
+	bytes           int64
+	grantedRequests int64
+	grantedBytes    int64
+}
+
+// quotaTracker 在記憶體中累計各範圍的用量。
+// 每個範圍在每個週期第一次被檢查時，會從存取紀錄與加值紀錄載入既有用量，之後由 Logger 持續累加。
+type quotaTracker struct {
+	mu       sync.Mutex
+	counters map[string]*quotaCounter
+	location *time.Location
+}
+
+var quotas = &quotaTracker{
+	counters: make(map[string]*quotaCounter),
+	location: loadQuotaLocation(),
+}
+
+// loadQuotaLocation 讀取配額重置所用的時區（QUOTA_TIMEZONE），預設為伺服器本地時區
+func loadQuotaLocation() *time.Location {
+	name := config.String("QUOTA_TIMEZONE", "")
+	if name == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		fmt.Printf("警告: QUOTA_TIMEZONE 的值 %q 無效，使用本地時區\n", name)
+		return time.Local
+	}
+	return loc
+}
+
+// quotaScopeColumn 回傳範圍在 access_logs 中對應的欄位
+func quotaScopeColumn(scope string) string {
+	switch scope {
+	case QuotaScopeUser:
+		return "user_id"
+	case QuotaScopeService:
+		return "service_id"
+	default:
+		return "token_id"
+	}
+}
+
+func quotaCounterKey(scope string, id uint, period string) string {
+	return scope + ":" + strconv.FormatUint(uint64(id), 10) + ":" + period
+}
+
+// counter 取得範圍在目前週期的用量，必要時從資料庫載入。呼叫前不可持有鎖，
+// 回傳的用量需在持有鎖時讀寫。
+func (q *quotaTracker) counter(scope string, id uint, quota models.Quota, now time.Time) (*quotaCounter, error) {
+	periodStart := quota.PeriodStart(now.In(q.location))
+	key := quotaCounterKey(scope, id, quota.Period)
+	q.mu.Lock()
+	if counter, ok := q.counters[key]; ok && counter.periodStart.Equal(periodStart) {
+		q.mu.Unlock()
+		return counter, nil
+	}
+	q.mu.Unlock()
+
+	// 在鎖外查詢資料庫，避免阻塞其他範圍的檢查與記錄
+	counter, err := loadQuotaCounter(scope, id, quota, periodStart)
+	if err != nil {
+		return nil, err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	// 載入期間可能已有其他請求載入同一週期，以先存入的為準，避免遺失已累加的用量
+	if existing, ok := q.counters[key]; ok && existing.periodStart.Equal(periodStart) {
+		return existing, nil
+	}
+	// 建立新週期的用量時，一併移除已結束週期的用量
+	for k, c := range q.counters {
+		if !now.Before(c.periodEnd) {
+			delete(q.counters, k)
+		}
+	}
+	q.counters[key] = counter
+	return counter, nil
+}
+
+// loadQuotaCounter 從存取紀錄與加值紀錄載入範圍在 periodStart 開始的週期內的用量
+func loadQuotaCounter(scope string, id uint, quota models.Quota, periodStart time.Time) (*quotaCounter, error) {
+	counter := &quotaCounter{periodStart: periodStart, periodEnd: quota.PeriodEnd(periodStart)}
+
+	// 載入本週期已記錄的用量（不含被閘道拒絕的請求）
+	var usage struct {
+		Requests int64
+		Bytes    int64
+	}
+	if err := db.DB.Model(&models.AccessLog{}).
+		Select("COUNT(*) AS requests, COALESCE(SUM(request_size + response_size), 0) AS bytes").
+		Where(quotaScopeColumn(scope)+" = ? AND created_at >= ? AND (reject_reason = '' OR reject_reason IS NULL)", id, periodStart.In(time.Local)).
+		Scan(&usage).Error; err != nil {
+		return nil, err
+	}
+	counter.requests = usage.Requests
+	counter.bytes = usage.Bytes
+
+	// 載入本週期的加值
+	var granted struct {
+		Requests int64
+		Bytes    int64
+	}
+	if err := db.DB.Model(&models.QuotaGrant{}).
+		Select("COALESCE(SUM(requests), 0) AS requests, COALESCE(SUM(bytes), 0) AS bytes").
+		Where("scope = ? AND scope_id = ? AND period = ? AND period_start = ?", scope, id, quota.Period, periodStart).
+		Scan(&granted).Error; err != nil {
+		return nil, err
+	}
+	counter.grantedRequests = granted.Requests
+	counter.grantedBytes = granted.Bytes
+	return counter, nil
+}
+
+// status 計算範圍的配額狀態
+func (q *quotaTracker) status(scope string, id uint, quota models.Quota, now time.Time) (QuotaStatus, error) {
+	status := QuotaStatus{Scope: scope, ScopeID: id, Quota: quota, Enabled: quota.Enabled()}
+	if !status.Enabled {
+		return status, nil
+	}
+
+	counter, err := q.counter(scope, id, quota, now)
+	if err != nil {
+		return status, err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	status.PeriodStart = counter.periodStart
+	status.ResetAt = counter.periodEnd
+	status.UsedRequests = counter.requests
+	status.UsedBytes = counter.bytes
+	status.GrantedRequests = counter.grantedRequests
+	status.GrantedBytes = counter.grantedBytes
+	if quota.MaxRequests > 0 {
+		remaining := quota.MaxRequests + counter.grantedRequests - counter.requests
+		status.RemainingRequests = &remaining
+	}
+	if quota.MaxBytes > 0 {
+		remaining := quota.MaxBytes + counter.grantedBytes - counter.bytes
+		status.RemainingBytes = &remaining
+	}
+	return status, nil
+}
+
+// record 將一次請求的用量累加到已載入的範圍，尚未載入的範圍會在下次檢查時從資料庫讀取
+func (q *quotaTracker) record(scope string, id uint, quota models.Quota, bytes int64, now time.Time) {
+	if !quota.Enabled() {
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	counter, ok := q.counters[quotaCounterKey(scope, id, quota.Period)]
+	if !ok || !counter.periodStart.Equal(quota.PeriodStart(now.In(q.location))) {
+		return
+	}
+	counter.requests++
+	counter.bytes += bytes
+}
+
+// GetQuotaStatus 回傳範圍在目前週期的配額使用情況
+func GetQuotaStatus(scope string, id uint, quota models.Quota) (QuotaStatus, error) {
+	return quotas.status(scope, id, quota, time.Now())
+}
+
+// AddQuotaTopUp 為範圍在目前週期加值一次性的請求數與傳輸量
+func AddQuotaTopUp(scope string, id uint, quota models.Quota, requests, bytes int64, note string) (QuotaStatus, error) {
+	if !quota.Enabled() {
+		return QuotaStatus{}, fmt.Errorf("尚未設定配額")
+	}
+
+	now := time.Now()
+	counter, err := quotas.counter(scope, id, quota, now)
+	if err != nil {
+		return QuotaStatus{}, err
+	}
+	grant := models.QuotaGrant{
+		Scope:       scope,
+		ScopeID:     id,
+		Period:      quota.Period,
+		PeriodStart: counter.periodStart,
+		Requests:    requests,
+		Bytes:       bytes,
+		Note:        note,
+	}
+	if err := db.DB.Create(&grant).Error; err != nil {
+		return QuotaStatus{}, err
+	}
+	// 同一週期的用量已載入，之後的載入不會取代它，直接累加即可
+	quotas.mu.Lock()
+	counter.grantedRequests += requests
+	counter.grantedBytes += bytes
+	quotas.mu.Unlock()
+
+	return quotas.status(scope, id, quota, now)
+}
+
+// checkQuotas 依序檢查Token、使用者與服務的配額，回傳第一個已用盡的配額狀態
+func checkQuotas(token models.Token, user models.User, service models.Service) (*QuotaStatus, error) {
+	now := time.Now()
+	scopes := []struct {
+		scope string
+		id    uint
+		quota models.Quota
+	}{
+		{QuotaScopeToken, token.ID, token.Quota},
+		{QuotaScopeUser, user.ID, user.Quota},
+		{QuotaScopeService, service.ID, service.Quota},
+	}
+
+	for _, s := range scopes {
+		if !s.quota.Enabled() {
+			continue
+		}
+		status, err := quotas.status(s.scope, s.id, s.quota, now)
+		if err != nil {
+			return nil, err
+		}
+		if status.Exhausted() {
+			return &status, nil
+		}
+	}
+	return nil, nil
+}
+
+// recordQuotaUsage 將完成的請求計入Token、使用者與服務的配額
+func recordQuotaUsage(token models.Token, user models.User, service models.Service, bytes int64) {
+	now := time.Now()
+	quotas.record(QuotaScopeToken, token.ID, token.Quota, bytes, now)
+	quotas.record(QuotaScopeUser, user.ID, user.Quota, bytes, now)
+	quotas.record(QuotaScopeService, service.ID, service.Quota, bytes, now)
+}
@@ -0,0 +1,74 @@
+package middlewares
+
+import (
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"infra-manager/db"
+	"infra-manager/models"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestMain(m *testing.M) {
+	// 配額用量從記憶體中的資料庫載入
+	database, err := gorm.Open(sqlite.Open("file:middlewares_test?mode=memory&cache=shared"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		log.Fatalf("無法開啟測試資料庫: %v", err)
+	}
+	if err := database.AutoMigrate(&models.AccessLog{}, &models.QuotaGrant{}); err != nil {
+		log.Fatalf("測試資料庫遷移失敗: %v", err)
+	}
+	db.DB = database
+
+	os.Exit(m.Run())
+}
+
+// 用量在週期內第一次檢查時從資料庫載入，之後在記憶體中累加；進入新週期時移除已結束週期的用量
+func TestQuotaCounterLoadsAndPrunes(t *testing.T) {
+	q := &quotaTracker{counters: make(map[string]*quotaCounter), location: time.Local}
+	quota := models.Quota{Period: models.QuotaPeriodDaily, MaxRequests: 3}
+	now := time.Now()
+
+	// 被閘道拒絕的請求不計入用量
+	logs := []models.AccessLog{
+		{TokenID: 101, RequestSize: 10, ResponseSize: 20},
+		{TokenID: 101, RequestSize: 5, ResponseSize: 5},
+		{TokenID: 101, RejectReason: RejectScopeDenied},
+	}
+	if err := db.DB.Create(&logs).Error; err != nil {
+		t.Fatal(err)
+	}
+	grant := models.QuotaGrant{Scope: QuotaScopeToken, ScopeID: 101, Period: quota.Period, PeriodStart: quota.PeriodStart(now), Requests: 1}
+	if err := db.DB.Create(&grant).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := q.status(QuotaScopeToken, 101, quota, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.UsedRequests != 2 || status.UsedBytes != 40 || status.GrantedRequests != 1 || *status.RemainingRequests != 2 {
+		t.Fatalf("載入的用量不正確: %+v", status)
+	}
+
+	q.record(QuotaScopeToken, 101, quota, 7, now)
+	if status, _ = q.status(QuotaScopeToken, 101, quota, now); status.UsedRequests != 3 || status.UsedBytes != 47 {
+		t.Errorf("記錄的用量應累加到已載入的用量: %+v", status)
+	}
+
+	// 另一個範圍在隔天第一次檢查時，昨天的用量應被移除
+	tomorrow := now.AddDate(0, 0, 1)
+	if _, err := q.status(QuotaScopeToken, 102, quota, tomorrow); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := q.counters[quotaCounterKey(QuotaScopeToken, 101, quota.Period)]; ok || len(q.counters) != 1 {
+		t.Errorf("已結束週期的用量未被移除，剩餘 %d 筆", len(q.counters))
+	}
+}
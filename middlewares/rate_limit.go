@@ -0,0 +1,182 @@
+package middlewares
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"infra-manager/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 限流拒絕原因，記錄於 AccessLog.RejectReason
+const (
+	RejectRateLimitedToken = "rate_limited_token"
+	RejectRateLimitedUser  = "rate_limited_user"
+)
+
+// bucket 是單一 token bucket 的狀態
+type bucket struct {
+	limit    models.RateLimit
+	tokens   float64
+	lastSeen time.Time
+}
+
+// refill 依經過時間補充 bucket
+func (b *bucket) refill(now time.Time) {
+	elapsed := now.Sub(b.lastSeen).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(float64(b.limit.Capacity()), b.tokens+elapsed*b.rate())
+	}
+	b.lastSeen = now
+}
+
+// rate 回傳每秒補充的數量
+func (b *bucket) rate() float64 {
+	return float64(b.limit.Requests) / b.limit.Period().Seconds()
+}
+
+// wait 回傳取得下一個請求額度所需的時間
+func (b *bucket) wait() time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate() * float64(time.Second))
+}
+
+// untilFull 回傳 bucket 補滿所需的時間
+func (b *bucket) untilFull() time.Duration {
+	missing := float64(b.limit.Capacity()) - b.tokens
+	if missing <= 0 {
+		return 0
+	}
+	return time.Duration(missing / b.rate() * float64(time.Second))
+}
+
+// rateLimiter 以記憶體保存每個Token與使用者的 bucket
+type rateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+var limiter = &rateLimiter{buckets: make(map[string]*bucket)}
+
+// bucketIdleTimeout 是閒置 bucket 被清除前的時間
+const bucketIdleTimeout = 10 * time.Minute
+
+// limitCheck 是一個需要檢查的限流與其拒絕原因
+type limitCheck struct {
+	key    string
+	limit  models.RateLimit
+	reason string
+}
+
+// allow 檢查所有限流，全部通過時才會扣除額度。
+// 回傳最嚴格的 bucket（剩餘額度最少者）供設定回應標頭，以及被拒絕時的原因。
+func (l *rateLimiter) allow(checks []limitCheck, now time.Time) (*bucket, string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	buckets := make([]*bucket, len(checks))
+	var tightest *bucket
+	for i, check := range checks {
+		b, ok := l.buckets[check.key]
+		if !ok || b.limit != check.limit {
+			// 新的 bucket 或設定已變更時以滿額重新開始
+			b = &bucket{limit: check.limit, tokens: float64(check.limit.Capacity()), lastSeen: now}
+			l.buckets[check.key] = b
+		}
+		b.refill(now)
+		buckets[i] = b
+
+		if b.tokens < 1 {
+			return b, check.reason
+		}
+		if tightest == nil || b.tokens < tightest.tokens {
+			tightest = b
+		}
+	}
+
+	for _, b := range buckets {
+		b.tokens--
+	}
+	return tightest, ""
+}
+
+// sweep 定期清除閒置的 bucket。呼叫前需持有鎖。
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < bucketIdleTimeout {
+		return
+	}
+	for key, b := range l.buckets {
+		if now.Sub(b.lastSeen) > bucketIdleTimeout {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// RateLimit 是代理請求的限流中間件，需放在 TokenAuth 之後。
+//   - Token 設定了限流時使用 Token 的設定，否則使用服務的預設限流（每個Token各自計算）
+//   - 使用者設定了限流時，該使用者所有Token的請求合併計算
+//
+// 回應會帶有 RateLimit-Limit、RateLimit-Remaining、RateLimit-Reset 標頭；
+// 超過限制時回傳 429 與 Retry-After，並在上下文設定 rejectReason 供 Logger 記錄。
+func RateLimit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.MustGet("token").(models.Token)
+		service := c.MustGet("service").(models.Service)
+		user := c.MustGet("user").(models.User)
+
+		var checks []limitCheck
+		tokenLimit := service.RateLimit
+		if token.RateLimit.Enabled() {
+			tokenLimit = token.RateLimit
+		}
+		if tokenLimit.Enabled() {
+			checks = append(checks, limitCheck{
+				key:    "token:" + strconv.FormatUint(uint64(token.ID), 10),
+				limit:  tokenLimit,
+				reason: RejectRateLimitedToken,
+			})
+		}
+		if user.RateLimit.Enabled() {
+			checks = append(checks, limitCheck{
+				key:    "user:" + strconv.FormatUint(uint64(user.ID), 10),
+				limit:  user.RateLimit,
+				reason: RejectRateLimitedUser,
+			})
+		}
+		if len(checks) == 0 {
+			c.Next()
+			return
+		}
+
+		b, reason := limiter.allow(checks, time.Now())
+		setRateLimitHeaders(c, b)
+
+		if reason != "" {
+			retryAfter := int(math.Ceil(b.wait().Seconds()))
+			c.Header("Retry-After", strconv.Itoa(max(retryAfter, 1)))
+			c.Set("rejectReason", reason)
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "請求過於頻繁，請稍後再試"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// setRateLimitHeaders 依 IETF RateLimit 標頭草案設定回應標頭
+func setRateLimitHeaders(c *gin.Context, b *bucket) {
+	c.Header("RateLimit-Limit", strconv.Itoa(b.limit.Capacity()))
+	c.Header("RateLimit-Remaining", strconv.Itoa(int(math.Max(0, math.Floor(b.tokens)))))
+	c.Header("RateLimit-Reset", strconv.Itoa(int(math.Ceil(b.untilFull().Seconds()))))
+	c.Header("RateLimit-Policy", strconv.Itoa(b.limit.Requests)+";w="+strconv.Itoa(int(b.limit.Period().Seconds()))+";burst="+strconv.Itoa(b.limit.Capacity()))
+}
@@ -0,0 +1,100 @@
+package middlewares
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"infra-manager/models"
+)
+
+// 超出Token存取範圍的拒絕原因
+const RejectScopeDenied = "scope_denied"
+
+// checkTokenScope 檢查請求的方法與端點是否在Token的存取範圍內，不允許時回傳原因
+func checkTokenScope(scope models.TokenScope, method, endpoint string) (string, bool) {
+	if containsMethod(scope.DeniedMethods, method) {
+		return fmt.Sprintf("不允許使用 %s 方法", method), false
+	}
+	if len(scope.AllowedMethods) > 0 && !containsMethod(scope.AllowedMethods, method) {
+		return fmt.Sprintf("不允許使用 %s 方法", method), false
+	}
+
+	// endpoint 已由 urlpath.NormalizeEndpoint 解析 . 與 ..，此處再合併重複斜線後比對
+	cleaned := path.Clean("/" + endpoint)
+	for _, pattern := range scope.DeniedPaths {
+		if matchPathGlob(pattern, cleaned) {
+			return fmt.Sprintf("不允許存取路徑 %s", cleaned), false
+		}
+	}
+	if len(scope.AllowedPaths) > 0 {
+		for _, pattern := range scope.AllowedPaths {
+			if matchPathGlob(pattern, cleaned) {
+				return "", true
+			}
+		}
+		return fmt.Sprintf("不允許存取路徑 %s", cleaned), false
+	}
+
+	return "", true
+}
+
+// ValidateTokenScope 檢查存取範圍中的路徑樣式是否有效
+func ValidateTokenScope(scope models.TokenScope) error {
+	for _, pattern := range append(append([]string{}, scope.AllowedPaths...), scope.DeniedPaths...) {
+		for _, segment := range splitPath(pattern) {
+			if segment == "**" {
+				continue
+			}
+			if _, err := path.Match(segment, ""); err != nil {
+				return fmt.Errorf("無效的路徑樣式 %q", pattern)
+			}
+		}
+	}
+	return nil
+}
+
+func containsMethod(methods []string, method string) bool {
+	for _, m := range methods {
+		if strings.EqualFold(strings.TrimSpace(m), method) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPathGlob 以路徑段比對 glob 樣式，** 可比對零到多個路徑段
+func matchPathGlob(pattern, p string) bool {
+	return matchSegments(splitPath(pattern), splitPath(p))
+}
+
+func matchSegments(pattern, segments []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// 嘗試讓 ** 比對 0 到所有剩餘路徑段
+			for i := 0; i <= len(segments); i++ {
+				if matchSegments(pattern[1:], segments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segments) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pattern[0], segments[0]); err != nil || !ok {
+			return false
+		}
+		pattern = pattern[1:]
+		segments = segments[1:]
+	}
+	return len(segments) == 0
+}
+
+func splitPath(p string) []string {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return nil
+	}
+	return strings.Split(p, "/")
+}
@@ -0,0 +1,29 @@
+package middlewares
+
+import (
+	"testing"
+
+	"infra-manager/models"
+	"infra-manager/urlpath"
+)
+
+// 空路徑段與 .. 的組合不能讓存取範圍檢查與轉發給後端的路徑不同
+func TestTokenScopeDotSegmentBypass(t *testing.T) {
+	scope := models.TokenScope{DeniedPaths: []string{"/v1/secret/**"}}
+	for _, endpoint := range []string{
+		"v1/secret/public",
+		"v1/secret//../public",
+		"v1/secret///../../public",
+		"v1//secret/public",
+		"v1/public/../secret/key",
+	} {
+		normalized := urlpath.NormalizeEndpoint(endpoint)
+		if _, ok := checkTokenScope(scope, "GET", normalized); ok {
+			t.Errorf("端點 %q（正規化為 %q）應被拒絕", endpoint, normalized)
+		}
+	}
+
+	if _, ok := checkTokenScope(scope, "GET", urlpath.NormalizeEndpoint("v1/secret/../public")); !ok {
+		t.Error("v1/secret/../public 解析為 /v1/public，應允許存取")
+	}
+}
@@ -1,6 +1,9 @@
 package models
 
 import (
+	"crypto/tls"
+	"fmt"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
@@ -12,6 +15,8 @@ type User struct {
 	ID         uint        `gorm:"primaryKey" json:"id"`
 	Username   string      `gorm:"unique;not null" json:"username"`
 	IsActive   bool        `gorm:"default:true" json:"is_active"`
+	RateLimit  RateLimit   `gorm:"embedded;embeddedPrefix:rate_limit_" json:"rate_limit"` // 該使用者所有Token合計的限流
+	Quota      Quota       `gorm:"embedded;embeddedPrefix:quota_" json:"quota"`           // 該使用者所有Token合計的用量配額
 	Tokens     []Token     `gorm:"foreignKey:UserID" json:"tokens,omitempty"`
 	AccessLogs []AccessLog `gorm:"foreignKey:UserID" json:"access_logs,omitempty"`
 }
@@ -19,20 +24,563 @@ type User struct {
 // 服務模型
 type Service struct {
 	gorm.Model
-	ID          uint        `gorm:"primaryKey" json:"id"`
-	Name        string      `gorm:"unique;not null" json:"name"`
-	Description string      `json:"description"`
-	BaseURL     string      `gorm:"not null" json:"base_url"`
-	IsActive    bool        `gorm:"default:true" json:"is_active"`
-	Tokens      []Token     `gorm:"foreignKey:ServiceID" json:"tokens,omitempty"`
-	AccessLogs  []AccessLog `gorm:"foreignKey:ServiceID" json:"access_logs,omitempty"`
+	ID              uint           `gorm:"primaryKey" json:"id"`
+	Name            string         `gorm:"unique;not null" json:"name"`
+	Description     string         `json:"description"`
+	BaseURL         string         `gorm:"not null" json:"base_url"`
+	IsActive        bool           `gorm:"default:true" json:"is_active"`
+	TokenSources    string         `gorm:"default:'path,header'" json:"token_sources"`            // 允許的Token來源，逗號分隔：path、header、bearer、query
+	TokenQueryParam string         `gorm:"default:'token'" json:"token_query_param"`              // 以 query 傳遞Token時的參數名稱
+	RateLimit       RateLimit      `gorm:"embedded;embeddedPrefix:rate_limit_" json:"rate_limit"` // 每個Token的預設限流
+	Quota           Quota          `gorm:"embedded;embeddedPrefix:quota_" json:"quota"`           // 服務所有Token合計的用量配額
+	IPAllowlist     []string       `gorm:"serializer:json;type:text" json:"ip_allowlist"`         // 允許的來源 IP 或 CIDR，空白表示不限制
+	IPDenylist      []string       `gorm:"serializer:json;type:text" json:"ip_denylist"`          // 拒絕的來源 IP 或 CIDR，優先於允許清單
+	Upstreams       []Upstream     `gorm:"serializer:json;type:text" json:"upstreams"`            // 多個後端目標，設定後取代 BaseURL
+	LoadBalancing   string         `gorm:"default:'round_robin'" json:"load_balancing"`           // 負載平衡方式：round_robin、least_conn、token_hash
+	Routes          []RouteRule    `gorm:"serializer:json;type:text" json:"routes"`               // 路由規則，依序比對，第一條符合的規則生效
+	HealthCheck     HealthCheck    `gorm:"embedded;embeddedPrefix:health_check_" json:"health_check"`
+	Timeouts        Timeouts       `gorm:"embedded;embeddedPrefix:timeout_" json:"timeouts"`
+	Retry           RetryPolicy    `gorm:"embedded;embeddedPrefix:retry_" json:"retry"`
+	CircuitBreaker  CircuitBreaker `gorm:"embedded;embeddedPrefix:circuit_breaker_" json:"circuit_breaker"`
+	Transport       Transport      `gorm:"embedded;embeddedPrefix:transport_" json:"transport"`
+	Cache           ResponseCache  `gorm:"embedded;embeddedPrefix:cache_" json:"cache"`
+	BodyLimits      BodyLimits     `gorm:"embedded;embeddedPrefix:body_limit_" json:"body_limits"`
+	Streaming       Streaming      `gorm:"embedded;embeddedPrefix:stream_" json:"streaming"`
+	TLS             UpstreamTLS    `gorm:"embedded;embeddedPrefix:tls_" json:"tls"`
+	Mirror          Mirror         `gorm:"embedded;embeddedPrefix:mirror_" json:"mirror"`
+	ForwardHeaders  string         `gorm:"default:'x-forwarded'" json:"forward_headers"` // 轉送給後端的代理標頭：x-forwarded、forwarded、both、none
+	Identity        string         `json:"identity"`                                     // 傳遞給後端的使用者身分：空白（不傳遞）、headers、jwt、both
+	WebApp          bool           `gorm:"default:false" json:"web_app"`                 // 網頁應用模式：改寫 Location、Refresh 與 cookie Path 到代理路徑前綴
+	RewriteBody     string         `json:"rewrite_body"`                                 // 改寫回應 body 中的網址：空白（不改寫）、html、html_css
+	Tokens          []Token        `gorm:"foreignKey:ServiceID" json:"tokens,omitempty"`
+	AccessLogs      []AccessLog    `gorm:"foreignKey:ServiceID" json:"access_logs,omitempty"`
+}
+
+// 後端目標
+type Upstream struct {
+	URL    string `json:"url"`
+	Weight int    `json:"weight"` // 權重，0 視為 1
+}
+
+// 路由規則：改寫轉發給後端的路徑，或將特定路徑導向其他後端
+type RouteRule struct {
+	Match    string `json:"match"`    // 比對方式：prefix、regex
+	Pattern  string `json:"pattern"`  // prefix 為路徑前綴（例如 /v2 或 /v2/**，以路徑段為單位比對）；regex 為 Go 正規表示式
+	Rewrite  string `json:"rewrite"`  // 改寫後的路徑，空白表示不改寫；prefix 取代相符的前綴，regex 取代相符的部分並可使用 $1 等群組
+	Upstream string `json:"upstream"` // 選填，符合時改送往此後端 URL（不套用負載平衡與健康檢查）
+}
+
+// 路由規則的比對方式
+const (
+	RouteMatchPrefix = "prefix"
+	RouteMatchRegex  = "regex"
+)
+
+// 負載平衡方式
+const (
+	LoadBalancingRoundRobin = "round_robin" // 加權輪詢
+	LoadBalancingLeastConn  = "least_conn"  // 進行中請求數（依權重）最少者
+	LoadBalancingTokenHash  = "token_hash"  // 依Token做一致性雜湊，同一Token固定導向同一目標
+)
+
+// 主動健康檢查設定，Path 為空表示不檢查（所有目標視為健康）
+type HealthCheck struct {
+	Path               string `json:"path"`                // 探測路徑，例如 /healthz；回應 2xx 或 3xx 視為成功
+	IntervalSeconds    int    `json:"interval_seconds"`    // 探測間隔，預設 10 秒
+	TimeoutSeconds     int    `json:"timeout_seconds"`     // 探測逾時，預設 2 秒
+	HealthyThreshold   int    `json:"healthy_threshold"`   // 連續成功幾次後恢復，預設 2
+	UnhealthyThreshold int    `json:"unhealthy_threshold"` // 連續失敗幾次後移出，預設 3
+}
+
+// Enabled 判斷是否啟用健康檢查
+func (h HealthCheck) Enabled() bool {
+	return strings.TrimSpace(h.Path) != ""
+}
+
+// Interval 回傳探測間隔
+func (h HealthCheck) Interval() time.Duration {
+	if h.IntervalSeconds <= 0 {
+		return 10 * time.Second
+	}
+	return time.Duration(h.IntervalSeconds) * time.Second
+}
+
+// Timeout 回傳探測逾時
+func (h HealthCheck) Timeout() time.Duration {
+	if h.TimeoutSeconds <= 0 {
+		return 2 * time.Second
+	}
+	return time.Duration(h.TimeoutSeconds) * time.Second
+}
+
+// Thresholds 回傳恢復與移出所需的連續成功、失敗次數
+func (h HealthCheck) Thresholds() (healthy, unhealthy int) {
+	healthy, unhealthy = h.HealthyThreshold, h.UnhealthyThreshold
+	if healthy <= 0 {
+		healthy = 2
+	}
+	if unhealthy <= 0 {
+		unhealthy = 3
+	}
+	return healthy, unhealthy
+}
+
+// 轉發至後端的逾時設定，0 表示使用預設值
+type Timeouts struct {
+	ConnectSeconds        int `json:"connect_seconds"`         // 建立連線逾時，預設 10 秒
+	ResponseHeaderSeconds int `json:"response_header_seconds"` // 送出請求後等待回應標頭的逾時，預設 60 秒
+	TotalSeconds          int `json:"total_seconds"`           // 整個請求（含讀取回應 body）的逾時，預設不限制；不適用於協定升級
+}
+
+// Connect 回傳建立連線逾時
+func (t Timeouts) Connect() time.Duration {
+	if t.ConnectSeconds <= 0 {
+		return 10 * time.Second
+	}
+	return time.Duration(t.ConnectSeconds) * time.Second
+}
+
+// ResponseHeader 回傳等待回應標頭的逾時
+func (t Timeouts) ResponseHeader() time.Duration {
+	if t.ResponseHeaderSeconds <= 0 {
+		return 60 * time.Second
+	}
+	return time.Duration(t.ResponseHeaderSeconds) * time.Second
+}
+
+// Total 回傳整個請求的逾時，0 表示不限制
+func (t Timeouts) Total() time.Duration {
+	if t.TotalSeconds <= 0 {
+		return 0
+	}
+	return time.Duration(t.TotalSeconds) * time.Second
+}
+
+// 重試設定。只有冪等方法（GET、HEAD、OPTIONS、PUT、DELETE、TRACE）在連線失敗或後端回應 502、503、504 時重試
+type RetryPolicy struct {
+	MaxRetries   int   `json:"max_retries"`    // 最多重試次數，0 表示不重試
+	BackoffMs    int   `json:"backoff_ms"`     // 第一次重試前的等待時間，之後每次加倍，預設 100 毫秒
+	MaxBodyBytes int64 `json:"max_body_bytes"` // 可重送的請求 body 上限，超過時不重試，預設 64KB
+}
+
+// Backoff 回傳第 attempt 次重試（從 1 開始）前的基本等待時間，上限 5 秒
+func (r RetryPolicy) Backoff(attempt int) time.Duration {
+	base := time.Duration(r.BackoffMs) * time.Millisecond
+	if base <= 0 {
+		base = 100 * time.Millisecond
+	}
+	backoff := base << (attempt - 1)
+	if backoff <= 0 || backoff > 5*time.Second {
+		backoff = 5 * time.Second
+	}
+	return backoff
+}
+
+// BodyLimit 回傳可重送的請求 body 上限
+func (r RetryPolicy) BodyLimit() int64 {
+	if r.MaxBodyBytes <= 0 {
+		return 64 << 10
+	}
+	return r.MaxBodyBytes
+}
+
+// 連線池設定，0 表示使用預設值
+type Transport struct {
+	MaxIdleConns           int  `json:"max_idle_conns"`            // 所有目標合計保留的閒置連線數，預設 100
+	MaxIdleConnsPerHost    int  `json:"max_idle_conns_per_host"`   // 每個目標保留的閒置連線數，預設 32
+	MaxConnsPerHost        int  `json:"max_conns_per_host"`        // 每個目標的連線數上限，預設不限制
+	IdleConnTimeoutSeconds int  `json:"idle_conn_timeout_seconds"` // 閒置連線保留時間，預設 90 秒
+	KeepAliveSeconds       int  `json:"keep_alive_seconds"`        // TCP keep-alive 間隔，預設 30 秒
+	DisableHTTP2           bool `json:"disable_http2"`             // 停用對 https 目標協商 HTTP/2
+	H2C                    bool `gorm:"column:h2c" json:"h2c"`     // 以 HTTP/2 cleartext（prior knowledge）連線 http 目標，例如未使用 TLS 的 gRPC 後端
+}
+
+// IdleConns 回傳閒置連線數上限（合計與每個目標）
+func (t Transport) IdleConns() (total, perHost int) {
+	total, perHost = t.MaxIdleConns, t.MaxIdleConnsPerHost
+	if total <= 0 {
+		total = 100
+	}
+	if perHost <= 0 {
+		perHost = 32
+	}
+	return total, perHost
+}
+
+// IdleConnTimeout 回傳閒置連線保留時間
+func (t Transport) IdleConnTimeout() time.Duration {
+	if t.IdleConnTimeoutSeconds <= 0 {
+		return 90 * time.Second
+	}
+	return time.Duration(t.IdleConnTimeoutSeconds) * time.Second
+}
+
+// KeepAlive 回傳 TCP keep-alive 間隔
+func (t Transport) KeepAlive() time.Duration {
+	if t.KeepAliveSeconds <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(t.KeepAliveSeconds) * time.Second
+}
+
+// 熔斷設定。連線失敗或後端回應 502、503、504 視為失敗
+type CircuitBreaker struct {
+	FailureThreshold int `json:"failure_threshold"`  // 連續失敗幾次後熔斷，0 表示停用
+	OpenSeconds      int `json:"open_seconds"`       // 熔斷持續時間，之後允許少量試探請求，預設 30 秒
+	HalfOpenRequests int `json:"half_open_requests"` // 試探期間允許同時進行的請求數，預設 1
+}
+
+// Enabled 判斷是否啟用熔斷
+func (b CircuitBreaker) Enabled() bool {
+	return b.FailureThreshold > 0
+}
+
+// OpenDuration 回傳熔斷持續時間
+func (b CircuitBreaker) OpenDuration() time.Duration {
+	if b.OpenSeconds <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(b.OpenSeconds) * time.Second
+}
+
+// Trials 回傳試探期間允許同時進行的請求數
+func (b CircuitBreaker) Trials() int {
+	if b.HalfOpenRequests <= 0 {
+		return 1
+	}
+	return b.HalfOpenRequests
+}
+
+// 請求與回應 body 的大小限制
+type BodyLimits struct {
+	MaxRequestBytes          int64 `json:"max_request_bytes"`           // 請求 body 上限，超過時回傳 413，0 表示不限制
+	MaxBufferedResponseBytes int64 `json:"max_buffered_response_bytes"` // 代理端完整讀入回應的上限，超過時改為串流轉發，0 表示使用 RESPONSE_BUFFER_MAX_BYTES
+}
+
+// 流量鏡像設定：依抽樣比例將請求複製一份送往鏡像目標，鏡像的回應只記錄，不影響用戶端
+type Mirror struct {
+	URL                string  `json:"url"`                 // 鏡像目標，格式與後端目標相同，空白表示停用
+	SamplePercent      float64 `json:"sample_percent"`      // 抽樣比例（0～100）
+	MaxBodyBytes       int64   `json:"max_body_bytes"`      // 可複製的請求 body 上限，超過或長度未知時不送出鏡像請求，0 表示 64KB
+	TimeoutSeconds     int     `json:"timeout_seconds"`     // 鏡像請求的逾時（含讀取回應），0 表示 30 秒
+	IncludeCredentials bool    `json:"include_credentials"` // 鏡像請求加上與主要請求相同的轉送標頭、使用者身分與後端憑證，預設不加上
+}
+
+// Enabled 判斷是否啟用流量鏡像
+func (m Mirror) Enabled() bool {
+	return m.URL != "" && m.SamplePercent > 0
+}
+
+// BodyLimit 回傳可複製的請求 body 上限
+func (m Mirror) BodyLimit() int64 {
+	if m.MaxBodyBytes <= 0 {
+		return 64 << 10
+	}
+	return m.MaxBodyBytes
+}
+
+// Timeout 回傳鏡像請求的逾時
+func (m Mirror) Timeout() time.Duration {
+	if m.TimeoutSeconds <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(m.TimeoutSeconds) * time.Second
+}
+
+// 連線 https 後端的 TLS 設定。憑證與私鑰以 SERVICE_SECRET_KEY 加密保存，只能經由上傳 API 設定，任何 API 都不會回傳
+type UpstreamTLS struct {
+	ServerName          string     `json:"server_name"`                               // 覆寫 SNI 與驗證憑證時使用的主機名稱，空白表示使用目標的主機名稱
+	MinVersion          string     `json:"min_version"`                               // 最低 TLS 版本：1.0、1.1、1.2、1.3，空白表示 1.2
+	InsecureSkipVerify  bool       `json:"insecure_skip_verify"`                      // 不驗證後端憑證，僅供開發環境使用
+	CABundle            string     `gorm:"column:ca_bundle;type:text" json:"-"`       // 加密後的 CA 憑證（PEM），設定後取代系統信任的 CA
+	ClientCert          string     `gorm:"type:text" json:"-"`                        // 加密後的用戶端憑證（PEM），用於 mTLS
+	ClientKey           string     `gorm:"type:text" json:"-"`                        // 加密後的用戶端私鑰（PEM）
+	CAExpiresAt         *time.Time `gorm:"column:ca_expires_at" json:"ca_expires_at"` // CA 憑證中最早的到期時間
+	ClientCertSubject   string     `json:"client_cert_subject"`
+	ClientCertExpiresAt *time.Time `json:"client_cert_expires_at"`
+}
+
+// 以上傳 API 設定的 TLS 憑證種類，同時作為加密的附加驗證資料
+const (
+	TLSMaterialCA         = "ca"
+	TLSMaterialClientCert = "client_cert"
+	TLSMaterialClientKey  = "client_key"
+)
+
+// tlsVersions 是可設定的最低 TLS 版本
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ValidTLSVersion 判斷最低 TLS 版本是否有效，空白表示使用預設值
+func ValidTLSVersion(version string) bool {
+	_, ok := tlsVersions[version]
+	return ok || version == ""
+}
+
+// Version 回傳最低 TLS 版本，未設定時為 TLS 1.2
+func (t UpstreamTLS) Version() uint16 {
+	if version, ok := tlsVersions[t.MinVersion]; ok {
+		return version
+	}
+	return tls.VersionTLS12
+}
+
+// Configured 判斷是否有任何 TLS 設定，未設定時使用預設的 TLS 設定
+func (t UpstreamTLS) Configured() bool {
+	return t.ServerName != "" || t.MinVersion != "" || t.InsecureSkipVerify || t.CABundle != "" || t.ClientCert != ""
+}
+
+// TLSEncryptionContext 是加密 TLS 憑證時的附加驗證資料，綁定所屬服務與憑證種類
+func TLSEncryptionContext(serviceID uint, material string) string {
+	return fmt.Sprintf("service:%d:tls:%s", serviceID, material)
+}
+
+// 串流回應（SSE、chunked 或未指定長度的回應）的轉發設定
+type Streaming struct {
+	FlushIntervalMs  int `json:"flush_interval_ms"`  // 累積多久 flush 一次，0 表示每次寫入後立即 flush；gRPC 一律立即 flush
+	KeepAliveSeconds int `json:"keep_alive_seconds"` // SSE 串流閒置多久後送出註解行（: keepalive）避免被中間設備切斷，0 表示不送
+}
+
+// FlushInterval 回傳 flush 間隔，0 表示每次寫入後立即 flush
+func (s Streaming) FlushInterval() time.Duration {
+	if s.FlushIntervalMs <= 0 {
+		return 0
+	}
+	return time.Duration(s.FlushIntervalMs) * time.Millisecond
+}
+
+// KeepAlive 回傳 SSE keep-alive 間隔，0 表示不送
+func (s Streaming) KeepAlive() time.Duration {
+	if s.KeepAliveSeconds <= 0 {
+		return 0
+	}
+	return time.Duration(s.KeepAliveSeconds) * time.Second
+}
+
+// 回應快取設定。只快取 GET/HEAD 請求，並遵循後端的 Cache-Control、Expires、ETag 與 Vary
+type ResponseCache struct {
+	Enabled           bool     `json:"enabled"`
+	DefaultTTLSeconds int      `json:"default_ttl_seconds"`                          // 後端未指定有效期限時的快取時間，0 表示只快取後端明確允許的回應
+	KeyHeaders        []string `gorm:"serializer:json;type:text" json:"key_headers"` // 納入快取鍵的請求標頭，例如 Accept、Accept-Language
+	MaxEntryBytes     int64    `json:"max_entry_bytes"`                              // 單一回應 body 的上限，超過時（或超過回應緩衝上限時）不快取，預設 1MB
+}
+
+// DefaultTTL 回傳後端未指定有效期限時的快取時間
+func (r ResponseCache) DefaultTTL() time.Duration {
+	if r.DefaultTTLSeconds <= 0 {
+		return 0
+	}
+	return time.Duration(r.DefaultTTLSeconds) * time.Second
+}
+
+// EntryLimit 回傳單一回應 body 的上限
+func (r ResponseCache) EntryLimit() int64 {
+	if r.MaxEntryBytes <= 0 {
+		return 1 << 20
+	}
+	return r.MaxEntryBytes
+}
+
+// Targets 回傳服務的後端目標；未設定 Upstreams 時使用 BaseURL
+func (s Service) Targets() []Upstream {
+	if len(s.Upstreams) == 0 {
+		return []Upstream{{URL: s.BaseURL, Weight: 1}}
+	}
+	targets := make([]Upstream, len(s.Upstreams))
+	for i, target := range s.Upstreams {
+		if target.Weight <= 0 {
+			target.Weight = 1
+		}
+		targets[i] = target
+	}
+	return targets
+}
+
+// 限流週期
+const (
+	RateLimitPerSecond = "second"
+	RateLimitPerMinute = "minute"
+)
+
+// 限流設定，以 token bucket 實作；Requests 為 0 表示不限制
+type RateLimit struct {
+	Requests int    `json:"requests"` // 每個週期允許的請求數
+	Per      string `json:"per"`      // 週期：second 或 minute，預設 second
+	Burst    int    `json:"burst"`    // 可累積的突發請求數，0 表示與 Requests 相同
+}
+
+// Enabled 判斷是否設定了限流
+func (r RateLimit) Enabled() bool {
+	return r.Requests > 0
+}
+
+// Period 回傳限流週期的長度
+func (r RateLimit) Period() time.Duration {
+	if r.Per == RateLimitPerMinute {
+		return time.Minute
+	}
+	return time.Second
+}
+
+// Capacity 回傳 bucket 的容量
+func (r RateLimit) Capacity() int {
+	if r.Burst > r.Requests {
+		return r.Burst
+	}
+	return r.Requests
+}
+
+// 配額週期
+const (
+	QuotaPeriodDaily   = "daily"
+	QuotaPeriodMonthly = "monthly"
+)
+
+// 用量配額設定，依日曆週期（QUOTA_TIMEZONE 時區）重置；上限為 0 表示不限制
+type Quota struct {
+	Period      string `json:"period"`       // daily 或 monthly，空白表示不啟用
+	MaxRequests int64  `json:"max_requests"` // 每週期最大請求數
+	MaxBytes    int64  `json:"max_bytes"`    // 每週期最大傳輸量（請求與回應大小合計）
+}
+
+// Enabled 判斷是否設定了配額
+func (q Quota) Enabled() bool {
+	return (q.Period == QuotaPeriodDaily || q.Period == QuotaPeriodMonthly) && (q.MaxRequests > 0 || q.MaxBytes > 0)
+}
+
+// PeriodStart 回傳 t 所在週期的起始時間
+func (q Quota) PeriodStart(t time.Time) time.Time {
+	if q.Period == QuotaPeriodMonthly {
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// PeriodEnd 回傳以 start 為起點的週期結束（下次重置）時間
+func (q Quota) PeriodEnd(start time.Time) time.Time {
+	if q.Period == QuotaPeriodMonthly {
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// 配額加值紀錄，只在加值當下的週期內有效
+type QuotaGrant struct {
+	gorm.Model
+	Scope       string    `gorm:"index:idx_quota_grants_scope;not null" json:"scope"` // token、user 或 service
+	ScopeID     uint      `gorm:"index:idx_quota_grants_scope;not null" json:"scope_id"`
+	Period      string    `gorm:"index:idx_quota_grants_scope" json:"period"`
+	PeriodStart time.Time `gorm:"index:idx_quota_grants_scope" json:"period_start"`
+	Requests    int64     `json:"requests"`
+	Bytes       int64     `json:"bytes"`
+	Note        string    `json:"note"`
+}
+
+// 回應 body 的網址改寫方式
+const (
+	RewriteBodyHTML    = "html"     // 改寫 HTML 的 href、src、action 屬性並插入 <base>
+	RewriteBodyHTMLCSS = "html_css" // 另外改寫 CSS 回應與 <style> 中的 url()、@import
+)
+
+// RewritesCSS 判斷是否改寫 CSS 中的網址
+func (s Service) RewritesCSS() bool {
+	return s.RewriteBody == RewriteBodyHTMLCSS
+}
+
+// 服務的後端憑證，由代理注入請求，使用者只需持有本系統的Token。
+// 值以 SERVICE_SECRET_KEY 加密保存，任何 API 都不會回傳。
+type ServiceSecret struct {
+	gorm.Model
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	ServiceID  uint       `gorm:"uniqueIndex:idx_service_secrets_name;not null" json:"service_id"`
+	Name       string     `gorm:"uniqueIndex:idx_service_secrets_name;not null" json:"name"` // 用於辨識的名稱，建立後不可變更
+	Placement  string     `gorm:"not null" json:"placement"`                                 // 注入方式：header、query、basic_auth
+	Key        string     `json:"key"`                                                       // 標頭或查詢參數名稱，basic_auth 不使用
+	Ciphertext string     `gorm:"not null" json:"-"`                                         // 加密後的值；basic_auth 為 username:password
+	Version    int        `gorm:"default:1" json:"version"`                                  // 每次輪替加一
+	RotatedAt  *time.Time `json:"rotated_at"`
+}
+
+// 後端憑證的注入方式
+const (
+	SecretPlacementHeader    = "header"     // 設定標頭，取代用戶端送來的同名標頭
+	SecretPlacementQuery     = "query"      // 設定查詢參數，取代用戶端送來的同名參數
+	SecretPlacementBasicAuth = "basic_auth" // Authorization: Basic
+)
+
+// EncryptionContext 是加密憑證時的附加驗證資料，綁定所屬服務與名稱
+func (s ServiceSecret) EncryptionContext() string {
+	return fmt.Sprintf("service:%d:secret:%s", s.ServiceID, s.Name)
+}
+
+// 轉送給後端的代理標頭
+const (
+	ForwardHeadersXForwarded = "x-forwarded" // X-Forwarded-For、X-Forwarded-Proto、X-Forwarded-Host、X-Forwarded-Prefix
+	ForwardHeadersForwarded  = "forwarded"   // RFC 7239 Forwarded
+	ForwardHeadersBoth       = "both"
+	ForwardHeadersNone       = "none"
+)
+
+// SendsXForwarded 判斷是否轉送 X-Forwarded-* 標頭，未設定時預設轉送
+func (s Service) SendsXForwarded() bool {
+	return s.ForwardHeaders == "" || s.ForwardHeaders == ForwardHeadersXForwarded || s.ForwardHeaders == ForwardHeadersBoth
+}
+
+// SendsForwarded 判斷是否轉送 RFC 7239 Forwarded 標頭
+func (s Service) SendsForwarded() bool {
+	return s.ForwardHeaders == ForwardHeadersForwarded || s.ForwardHeaders == ForwardHeadersBoth
+}
+
+// 傳遞給後端的使用者身分
+const (
+	IdentityHeaders = "headers" // X-Infra-User、X-Infra-User-ID、X-Infra-Token-ID
+	IdentityJWT     = "jwt"     // X-Infra-Identity: 以 EdDSA 簽署的短效 JWT
+	IdentityBoth    = "both"
+)
+
+// SendsIdentityHeaders 判斷是否以標頭傳遞使用者身分
+func (s Service) SendsIdentityHeaders() bool {
+	return s.Identity == IdentityHeaders || s.Identity == IdentityBoth
+}
+
+// SendsIdentityJWT 判斷是否以簽署的 JWT 傳遞使用者身分
+func (s Service) SendsIdentityJWT() bool {
+	return s.Identity == IdentityJWT || s.Identity == IdentityBoth
+}
+
+// Token來源
+const (
+	TokenSourcePath   = "path"   // /use/<service>/<token>/...
+	TokenSourceHeader = "header" // X-Infra-Token: <token>
+	TokenSourceBearer = "bearer" // Authorization: Bearer <token>
+	TokenSourceQuery  = "query"  // ?<TokenQueryParam>=<token>
+)
+
+// AllowsTokenSource 判斷服務是否允許從指定來源讀取Token，未設定時僅允許 path 與 header
+func (s Service) AllowsTokenSource(source string) bool {
+	sources := s.TokenSources
+	if strings.TrimSpace(sources) == "" {
+		sources = TokenSourcePath + "," + TokenSourceHeader
+	}
+	for _, item := range strings.Split(sources, ",") {
+		if strings.EqualFold(strings.TrimSpace(item), source) {
+			return true
+		}
+	}
+	return false
 }
 
 // Token模型
 type Token struct {
 	gorm.Model
 	ID          uint        `gorm:"primaryKey" json:"id"`
-	TokenValue  string      `gorm:"unique;not null" json:"token_value"`
+	TokenHash   string      `gorm:"uniqueIndex;size:64" json:"-"`   // Token的 HMAC-SHA256 雜湊
+	TokenPrefix string      `gorm:"size:16" json:"token_prefix"`    // 用於顯示的Token前綴
+	TokenValue  string      `gorm:"-" json:"token_value,omitempty"` // 明文Token，僅在建立時回傳一次，不寫入資料庫
 	UserID      uint        `gorm:"not null" json:"user_id"`
 	User        User        `json:"user,omitempty"`
 	ServiceID   uint        `gorm:"not null" json:"service_id"`
@@ -40,22 +588,61 @@ type Token struct {
 	Description string      `json:"description"` // 新增備註說明欄位
 	ExpiresAt   time.Time   `json:"expires_at"`
 	IsActive    bool        `gorm:"default:true" json:"is_active"`
-	Disabled    bool        `gorm:"default:false" json:"disabled"` // 失效紀錄欄位
+	Disabled    bool        `gorm:"default:false" json:"disabled"`                         // 失效紀錄欄位
+	RateLimit   RateLimit   `gorm:"embedded;embeddedPrefix:rate_limit_" json:"rate_limit"` // 覆寫服務的預設限流
+	Quota       Quota       `gorm:"embedded;embeddedPrefix:quota_" json:"quota"`           // 此Token的用量配額
+	Scope       TokenScope  `gorm:"embedded;embeddedPrefix:scope_" json:"scope"`           // 允許存取的方法與路徑
+	IPAllowlist []string    `gorm:"serializer:json;type:text" json:"ip_allowlist"`         // 允許的來源 IP 或 CIDR，空白表示不限制
+	IPDenylist  []string    `gorm:"serializer:json;type:text" json:"ip_denylist"`          // 拒絕的來源 IP 或 CIDR，優先於允許清單
 	AccessLogs  []AccessLog `gorm:"foreignKey:TokenID" json:"access_logs,omitempty"`
 }
 
+// Token的存取範圍。允許清單為空表示不限制，拒絕清單優先於允許清單。
+// 路徑為 glob 樣式：* 比對單一路徑段、** 比對任意層路徑，例如 /v1/predict/**
+type TokenScope struct {
+	AllowedMethods []string `gorm:"serializer:json;type:text" json:"allowed_methods"`
+	DeniedMethods  []string `gorm:"serializer:json;type:text" json:"denied_methods"`
+	AllowedPaths   []string `gorm:"serializer:json;type:text" json:"allowed_paths"`
+	DeniedPaths    []string `gorm:"serializer:json;type:text" json:"denied_paths"`
+}
+
 // 使用紀錄模型
 type AccessLog struct {
 	gorm.Model
-	UserID       uint   `json:"user_id"`
-	TokenID      uint   `json:"token_id"`
-	ServiceID    uint   `json:"service_id"`
-	Endpoint     string `json:"endpoint"`
-	Method       string `json:"method"`
-	StatusCode   int    `json:"status_code"`
-	RequestSize  int64  `json:"request_size"`
-	ResponseSize int64  `json:"response_size"`
-	Duration     int64  `json:"duration"` // 毫秒
+	UserID         uint   `json:"user_id"`
+	TokenID        uint   `json:"token_id"`
+	ServiceID      uint   `json:"service_id"`
+	Endpoint       string `json:"endpoint"`
+	Method         string `json:"method"`
+	StatusCode     int    `json:"status_code"`
+	RequestSize    int64  `json:"request_size"`
+	ResponseSize   int64  `json:"response_size"`
+	Duration       int64  `json:"duration"`                              // 毫秒
+	Protocol       string `json:"protocol"`                              // 協定升級後的協定（例如 websocket），一般 HTTP 請求為空
+	RejectReason   string `json:"reject_reason"`                         // 被閘道拒絕的原因（例如 rate_limited_token），成功轉發時為空
+	ClientIP       string `json:"client_ip"`                             // 依信任的代理設定解析出的用戶端 IP
+	CacheStatus    string `json:"cache_status"`                          // 回應快取結果：HIT、MISS、REVALIDATED、BYPASS，服務未啟用快取時為空
+	GRPCStatus     string `gorm:"column:grpc_status" json:"grpc_status"` // gRPC 回應的 grpc-status（0 為 OK），非 gRPC 請求為空
+	StreamEvents   int64  `json:"stream_events"`                         // 串流回應送出的 SSE 事件數
+	StreamDuration int64  `json:"stream_duration"`                       // 串流回應從送出標頭到結束的時間（毫秒），非串流回應為 0
+}
+
+// 流量鏡像紀錄：鏡像請求的結果，以及與送給用戶端的主要回應的比較
+type MirrorLog struct {
+	gorm.Model
+	ServiceID       uint   `gorm:"index" json:"service_id"`
+	Method          string `json:"method"`
+	Endpoint        string `json:"endpoint"` // 與存取紀錄相同，Token 已遮蔽
+	Target          string `json:"target"`   // 鏡像目標
+	PrimaryStatus   int    `json:"primary_status"`
+	MirrorStatus    int    `json:"mirror_status"`    // 0 表示鏡像請求失敗或未送出
+	PrimaryDuration int64  `json:"primary_duration"` // 毫秒
+	MirrorDuration  int64  `json:"mirror_duration"`  // 毫秒
+	PrimarySize     int64  `json:"primary_size"`
+	MirrorSize      int64  `json:"mirror_size"`
+	StatusMatch     bool   `json:"status_match"`
+	BodyMatch       bool   `json:"body_match"` // 狀態碼相同且 body 的 SHA-256 相同
+	Error           string `json:"error"`      // 鏡像請求失敗或未送出（skipped_*）的原因
 }
 
 // 管理員模型
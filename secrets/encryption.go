@@ -0,0 +1,124 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ErrSecretKeyMissing 表示未設定加密後端憑證用的金鑰
+var ErrSecretKeyMissing = errors.New("未設定 SERVICE_SECRET_KEY，無法加密或解密後端憑證")
+
+type secretKey struct {
+	id   string // 金鑰 SHA-256 的前 4 bytes（十六進位），記錄在密文前以辨識加密時使用的金鑰
+	aead cipher.AEAD
+}
+
+var (
+	secretKeys     []secretKey // 第一把為目前金鑰，其後為 SERVICE_SECRET_PREVIOUS_KEYS 中的舊金鑰
+	secretKeysErr  error
+	secretKeysOnce sync.Once
+)
+
+// serviceSecretKeys 讀取 SERVICE_SECRET_KEY 與 SERVICE_SECRET_PREVIOUS_KEYS（逗號分隔），
+// 每把金鑰皆為 32 bytes 的十六進位字串。金鑰刻意不寫入資料夾，避免與資料庫一同外洩。
+func serviceSecretKeys() ([]secretKey, error) {
+	secretKeysOnce.Do(func() {
+		current := strings.TrimSpace(os.Getenv("SERVICE_SECRET_KEY"))
+		if current == "" {
+			secretKeysErr = ErrSecretKeyMissing
+			return
+		}
+
+		values := []string{current}
+		for _, value := range strings.Split(os.Getenv("SERVICE_SECRET_PREVIOUS_KEYS"), ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+
+		for _, value := range values {
+			key, err := hex.DecodeString(value)
+			if err != nil || len(key) != 32 {
+				secretKeysErr = errors.New("SERVICE_SECRET_KEY 與 SERVICE_SECRET_PREVIOUS_KEYS 必須是 32 bytes 的十六進位字串")
+				secretKeys = nil
+				return
+			}
+			block, err := aes.NewCipher(key)
+			if err != nil {
+				secretKeysErr = err
+				secretKeys = nil
+				return
+			}
+			aead, err := cipher.NewGCM(block)
+			if err != nil {
+				secretKeysErr = err
+				secretKeys = nil
+				return
+			}
+			sum := sha256.Sum256(key)
+			secretKeys = append(secretKeys, secretKey{id: hex.EncodeToString(sum[:4]), aead: aead})
+		}
+	})
+	return secretKeys, secretKeysErr
+}
+
+// EncryptSecret 以目前的金鑰（AES-256-GCM）加密憑證。
+// context 作為附加驗證資料，讓密文只能在原本的服務與名稱下解密，無法搬到其他紀錄使用。
+// 回傳格式為 <金鑰ID>:<base64(nonce + 密文)>。
+func EncryptSecret(plaintext, context string) (string, error) {
+	keys, err := serviceSecretKeys()
+	if err != nil {
+		return "", err
+	}
+	key := keys[0]
+
+	nonce := make([]byte, key.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := key.aead.Seal(nonce, nonce, []byte(plaintext), []byte(context))
+	return key.id + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptSecret 依密文記錄的金鑰ID選擇金鑰解密。current 表示是否以目前的金鑰加密，
+// 為 false 時應以 EncryptSecret 重新加密，之後才能移除舊金鑰。
+func DecryptSecret(ciphertext, context string) (plaintext string, current bool, err error) {
+	keys, err := serviceSecretKeys()
+	if err != nil {
+		return "", false, err
+	}
+
+	keyID, encoded, ok := strings.Cut(ciphertext, ":")
+	if !ok {
+		return "", false, errors.New("無效的憑證密文格式")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", false, errors.New("無效的憑證密文格式")
+	}
+
+	for i, key := range keys {
+		if key.id != keyID {
+			continue
+		}
+		if len(sealed) < key.aead.NonceSize() {
+			return "", false, errors.New("無效的憑證密文格式")
+		}
+		nonce, data := sealed[:key.aead.NonceSize()], sealed[key.aead.NonceSize():]
+		opened, err := key.aead.Open(nil, nonce, data, []byte(context))
+		if err != nil {
+			return "", false, fmt.Errorf("憑證解密失敗: %w", err)
+		}
+		return string(opened), i == 0, nil
+	}
+	return "", false, fmt.Errorf("找不到金鑰ID %s，請確認 SERVICE_SECRET_PREVIOUS_KEYS 是否包含加密時使用的金鑰", keyID)
+}
@@ -0,0 +1,91 @@
+package secrets
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+var (
+	identityKey     ed25519.PrivateKey
+	identityKeyID   string
+	identityKeyOnce sync.Once
+)
+
+// identitySigningKey 取得簽署身分 JWT 用的 Ed25519 私鑰。
+// 優先使用環境變數 IDENTITY_SIGNING_KEY（32 bytes 種子的十六進位）；未設定時讀取或產生 data/identity_signing_key。
+// 多個執行個體共用同一組後端時，應設定相同的 IDENTITY_SIGNING_KEY。
+func identitySigningKey() (ed25519.PrivateKey, string) {
+	identityKeyOnce.Do(func() {
+		var seed []byte
+		if value := strings.TrimSpace(os.Getenv("IDENTITY_SIGNING_KEY")); value != "" {
+			decoded, err := hex.DecodeString(value)
+			if err != nil || len(decoded) != ed25519.SeedSize {
+				log.Fatalf("IDENTITY_SIGNING_KEY 必須是 %d bytes 的十六進位字串", ed25519.SeedSize)
+			}
+			seed = decoded
+		} else {
+			key, err := loadOrCreateKeyFile("identity_signing_key", ed25519.SeedSize)
+			if err != nil || len(key) != ed25519.SeedSize {
+				log.Fatalf("無法載入身分簽章金鑰: %v", err)
+			}
+			seed = key
+		}
+
+		identityKey = ed25519.NewKeyFromSeed(seed)
+		// kid 取公鑰的 SHA-256 前 8 bytes，金鑰更換後後端可依 kid 重新取得 JWKS
+		sum := sha256.Sum256(identityKey.Public().(ed25519.PublicKey))
+		identityKeyID = base64.RawURLEncoding.EncodeToString(sum[:8])
+	})
+	return identityKey, identityKeyID
+}
+
+// JWK 是 RFC 8037 格式的 Ed25519 公鑰
+type JWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+}
+
+// IdentityJWKS 回傳驗證身分 JWT 用的公鑰集合（JWKS）
+func IdentityJWKS() map[string][]JWK {
+	key, kid := identitySigningKey()
+	return map[string][]JWK{
+		"keys": {{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(key.Public().(ed25519.PublicKey)),
+			Kid: kid,
+			Alg: "EdDSA",
+			Use: "sig",
+		}},
+	}
+}
+
+// SignIdentityJWT 以 EdDSA 簽署 JWT
+func SignIdentityJWT(claims any) (string, error) {
+	key, kid := identitySigningKey()
+
+	header, err := json.Marshal(map[string]string{"alg": "EdDSA", "typ": "JWT", "kid": kid})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("無法序列化 JWT 內容: %w", err)
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	signature := ed25519.Sign(key, []byte(signingInput))
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
@@ -0,0 +1,80 @@
+package secrets
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"sync"
+)
+
+// DataDir 是持久化金鑰檔案所在的資料夾，與資料庫相同
+const DataDir = "data"
+
+// TokenPrefixLength 是Token顯示前綴的長度
+const TokenPrefixLength = 8
+
+var (
+	tokenKey     []byte
+	tokenKeyOnce sync.Once
+)
+
+// tokenHashKey 取得計算Token雜湊用的伺服器金鑰。
+// 優先使用環境變數 TOKEN_HASH_SECRET；未設定時讀取或產生 data/token_secret。
+// 金鑰一旦變更，所有既有Token都將失效。
+func tokenHashKey() []byte {
+	tokenKeyOnce.Do(func() {
+		if secret := os.Getenv("TOKEN_HASH_SECRET"); secret != "" {
+			tokenKey = []byte(secret)
+			return
+		}
+
+		key, err := loadOrCreateKeyFile("token_secret", 32)
+		if err != nil {
+			log.Fatalf("無法載入Token雜湊金鑰: %v", err)
+		}
+		fmt.Println("警告: 未設定 TOKEN_HASH_SECRET 環境變數，使用 data/token_secret 作為Token雜湊金鑰")
+		tokenKey = key
+	})
+	return tokenKey
+}
+
+// HashToken 以 HMAC-SHA256 計算Token的雜湊值（十六進位）
+func HashToken(value string) string {
+	mac := hmac.New(sha256.New, tokenHashKey())
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// TokenPrefix 回傳Token用於顯示的前綴，最多 TokenPrefixLength 個字元且不超過Token長度的一半，
+// 較短的舊版Token也不會完整顯示
+func TokenPrefix(value string) string {
+	return value[:min(TokenPrefixLength, len(value)/2)]
+}
+
+// loadOrCreateKeyFile 讀取資料夾中的金鑰檔案，不存在時產生指定長度的隨機金鑰並寫入
+func loadOrCreateKeyFile(name string, size int) ([]byte, error) {
+	keyPath := path.Join(DataDir, name)
+	if content, err := os.ReadFile(keyPath); err == nil {
+		return hex.DecodeString(strings.TrimSpace(string(content)))
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key := make([]byte, size)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(DataDir, 0755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(keyPath, []byte(hex.EncodeToString(key)), 0600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
@@ -0,0 +1,54 @@
+package services
+
+import (
+	"errors"
+	"net/http"
+
+	"infra-manager/config"
+	"infra-manager/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RejectBodyTooLarge 是請求 body 超過服務上限時記錄的拒絕原因
+const RejectBodyTooLarge = "request_body_too_large"
+
+// defaultResponseBufferSize 是服務未設定時，代理端完整讀入回應的上限（RESPONSE_BUFFER_MAX_BYTES），
+// 超過時改為串流轉發
+var defaultResponseBufferSize = int64(config.Int("RESPONSE_BUFFER_MAX_BYTES", 1<<20))
+
+// limitRequestBody 依服務設定限制請求 body 的大小。Content-Length 已超過上限時回應 413 並回傳 false；
+// 未指定長度（chunked）的請求在轉發途中讀取超過上限時中斷，由 isBodyTooLarge 判斷後回應 413。
+func limitRequestBody(c *gin.Context, service models.Service) bool {
+	limit := service.BodyLimits.MaxRequestBytes
+	if limit <= 0 || c.Request.Body == nil || c.Request.Body == http.NoBody {
+		return true
+	}
+	if c.Request.ContentLength > limit {
+		respondBodyTooLarge(c, limit)
+		return false
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+	return true
+}
+
+// isBodyTooLarge 判斷錯誤是否因請求 body 超過上限
+func isBodyTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
+
+func respondBodyTooLarge(c *gin.Context, limit int64) {
+	c.Set("rejectReason", RejectBodyTooLarge)
+	// 不再讀取剩餘的請求 body，回應後關閉連線
+	c.Header("Connection", "close")
+	c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "請求內容過大", "limit": limit})
+}
+
+// responseBufferLimit 回傳代理端完整讀入回應的上限
+func responseBufferLimit(service models.Service) int64 {
+	if service.BodyLimits.MaxBufferedResponseBytes > 0 {
+		return service.BodyLimits.MaxBufferedResponseBytes
+	}
+	return defaultResponseBufferSize
+}
@@ -0,0 +1,141 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"infra-manager/models"
+)
+
+// echoLengthUpstream 讀完請求 body 並回傳讀到的位元組數
+func echoLengthUpstream(t *testing.T, calls *atomic.Int64) *httptest.Server {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		n, _ := io.Copy(io.Discard, r.Body)
+		io.WriteString(w, strconv.FormatInt(n, 10))
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream
+}
+
+func TestRequestBodyLimitContentLength(t *testing.T) {
+	var calls atomic.Int64
+	service := newTestService(echoLengthUpstream(t, &calls).URL)
+	service.BodyLimits.MaxRequestBytes = 1 << 20
+	proxy := newTestProxy(t, service)
+
+	// 上限以內的請求正常轉發
+	resp, err := http.Post(proxy.URL+"/use/test/upload", "application/octet-stream", bytes.NewReader(make([]byte, 1<<20)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != strconv.Itoa(1<<20) {
+		t.Fatalf("上限以內的請求應轉發，得到 %d %q", resp.StatusCode, body)
+	}
+
+	// Content-Length 超過上限時直接回應 413，不轉發給後端
+	resp, err = http.Post(proxy.URL+"/use/test/upload", "application/octet-stream", bytes.NewReader(make([]byte, 8<<20)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result struct {
+		Limit int64 `json:"limit"`
+	}
+	json.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("狀態碼 %d，預期 413", resp.StatusCode)
+	}
+	if result.Limit != 1<<20 {
+		t.Errorf("回應的上限為 %d，預期 %d", result.Limit, 1<<20)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("後端收到 %d 個請求，超過上限的請求不應轉發", calls.Load())
+	}
+}
+
+func TestRequestBodyLimitChunked(t *testing.T) {
+	var calls atomic.Int64
+	service := newTestService(echoLengthUpstream(t, &calls).URL)
+	service.BodyLimits.MaxRequestBytes = 1 << 20
+	proxy := newTestProxy(t, service)
+
+	// 不指定長度，以 chunked 傳送，超過上限的部分在轉發途中由 MaxBytesReader 中斷
+	req, _ := http.NewRequest(http.MethodPost, proxy.URL+"/use/test/upload", io.MultiReader(bytes.NewReader(make([]byte, 8<<20))))
+	req.ContentLength = -1
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("狀態碼 %d，預期 413", resp.StatusCode)
+	}
+}
+
+func TestLargeResponseStreamsPastBufferLimit(t *testing.T) {
+	testResponseStreamsPastBufferLimit(t, func(service *models.Service) {})
+}
+
+// 可快取的回應同樣不超過回應緩衝上限，快取項目上限較大時也不會完整讀入
+func TestCacheableResponseStreamsPastBufferLimit(t *testing.T) {
+	testResponseStreamsPastBufferLimit(t, func(service *models.Service) {
+		service.Cache = models.ResponseCache{Enabled: true, MaxEntryBytes: 16 << 20}
+	})
+}
+
+// testResponseStreamsPastBufferLimit 確認超過服務回應緩衝上限（64KB）的回應以串流轉發，configure 可調整服務的其他設定
+func testResponseStreamsPastBufferLimit(t *testing.T, configure func(service *models.Service)) {
+	const half = 2 << 20
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Content-Length", strconv.Itoa(2*half))
+		w.Write(bytes.Repeat([]byte("a"), half))
+		w.(http.Flusher).Flush()
+		// 代理完整讀入回應時，用戶端要等後端送完才收得到資料
+		select {
+		case <-release:
+		case <-time.After(5 * time.Second):
+			t.Error("後端送完回應前，用戶端未收到任何資料，回應被完整緩衝")
+		}
+		w.Write(bytes.Repeat([]byte("b"), half))
+	}))
+	defer upstream.Close()
+
+	service := newTestService(upstream.URL)
+	service.BodyLimits.MaxBufferedResponseBytes = 64 << 10
+	configure(&service)
+	proxy := newTestProxy(t, service)
+
+	resp, err := http.Get(proxy.URL + "/use/test/download")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.ContentLength != 2*half {
+		t.Errorf("Content-Length 為 %d，串流時應沿用後端的值 %d", resp.ContentLength, 2*half)
+	}
+
+	first := make([]byte, half)
+	if _, err := io.ReadFull(resp.Body, first); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	rest, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first, bytes.Repeat([]byte("a"), half)) || !bytes.Equal(rest, bytes.Repeat([]byte("b"), half)) {
+		t.Error("串流轉發的回應內容與後端不同")
+	}
+}
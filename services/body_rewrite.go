@@ -0,0 +1,287 @@
+package services
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"infra-manager/config"
+	"infra-manager/models"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/html"
+)
+
+// maxRewriteBodySize 是改寫回應 body 的大小上限（BODY_REWRITE_MAX_BYTES），壓縮前後都不可超過，
+// 超過時原樣串流轉發
+var maxRewriteBodySize = int64(config.Int("BODY_REWRITE_MAX_BYTES", 8<<20))
+
+var (
+	cssURLPattern    = regexp.MustCompile(`(?i)(url\(\s*)(['"]?)([^'"()\s]+)(['"]?\s*\))`)
+	cssImportPattern = regexp.MustCompile(`(?i)(@import\s+)(['"])([^'"]+)(['"])`)
+	baseTagPattern   = regexp.MustCompile(`(?i)<base[\s>]`)
+	headTagPattern   = regexp.MustCompile(`(?i)<head[\s>]`)
+)
+
+// bodyRewriter 將 HTML 的 href、src、action 屬性（以及 CSS 的 url() 與 @import）中指向後端的網址改寫到代理路徑前綴
+type bodyRewriter struct {
+	location *locationRewriter
+	document *url.URL // 用戶端請求的網址（含代理前綴），用於解析相對網址
+	css      bool     // 是否改寫 CSS（text/css 回應與 <style> 區塊）
+	// injectBase 表示會在 <head> 後插入 <base href="<前綴>/">，
+	// 此時相對網址需先依目前網址解析，避免被 <base> 改變指向
+	injectBase bool
+}
+
+// writeRewrittenBody 在服務啟用 body 改寫且回應為 HTML（或 CSS）時，讀取、解壓縮、改寫後重新壓縮並寫出回應。
+// 串流回應（chunked 或長度未知）與超過緩衝上限的回應不讀入記憶體，原樣轉發。
+// 回傳 false 表示不需改寫，回應 body 尚未讀取，由呼叫端照常轉發。
+func writeRewrittenBody(c *gin.Context, service models.Service, resp *http.Response) bool {
+	if service.RewriteBody == "" || c.Request.Method == http.MethodHead ||
+		resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified {
+		return false
+	}
+	if shouldStream(resp) || resp.ContentLength > responseBufferLimit(service) {
+		return false
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	isHTML := mediaType == "text/html" || mediaType == "application/xhtml+xml"
+	isCSS := mediaType == "text/css" && service.RewritesCSS()
+	if !isHTML && !isCSS {
+		return false
+	}
+
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, maxRewriteBodySize+1))
+	if err != nil {
+		respondReadError(c, err)
+		return true
+	}
+	if int64(len(raw)) > maxRewriteBodySize {
+		// 過大的回應不改寫，將已讀取的部分與剩餘內容串接後原樣串流
+		c.Writer.Header().Del("Content-Length")
+		c.Status(resp.StatusCode)
+		if _, err := io.Copy(c.Writer, io.MultiReader(bytes.NewReader(raw), resp.Body)); err != nil {
+			c.Error(err)
+		}
+		return true
+	}
+
+	body := raw
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	if decoded, ok := decodeBody(raw, encoding); ok {
+		r := newBodyRewriter(c, service, resp)
+		var rewritten []byte
+		if isHTML {
+			rewritten = r.rewriteHTML(decoded)
+		} else {
+			rewritten = r.rewriteCSS(decoded, false)
+		}
+		if !bytes.Equal(rewritten, decoded) {
+			if encoded, err := encodeBody(rewritten, encoding); err == nil {
+				body = encoded
+				// 內容已變更，後端的 ETag 與摘要不再適用
+				c.Writer.Header().Del("Etag")
+				c.Writer.Header().Del("Content-Md5")
+			}
+		}
+	}
+
+	c.Writer.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	c.Writer.Header().Del("Transfer-Encoding")
+	c.Status(resp.StatusCode)
+	if _, err := c.Writer.Write(body); err != nil {
+		c.Error(err)
+	}
+	return true
+}
+
+func newBodyRewriter(c *gin.Context, service models.Service, resp *http.Response) *bodyRewriter {
+	return &bodyRewriter{
+		location: newLocationRewriter(c, service, resp),
+		document: c.Request.URL,
+		css:      service.RewritesCSS(),
+	}
+}
+
+// rewriteHTML 逐一處理 HTML token，只改寫標籤屬性與 <style> 內容，其餘內容（包含 <script>）原樣保留
+func (r *bodyRewriter) rewriteHTML(doc []byte) []byte {
+	r.injectBase = !baseTagPattern.Match(doc) && headTagPattern.Match(doc)
+
+	var out bytes.Buffer
+	out.Grow(len(doc) + 64)
+	z := html.NewTokenizer(bytes.NewReader(doc))
+	inStyle := false
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			// 從記憶體讀取，只會在文件結尾（io.EOF）結束
+			out.Write(z.Raw())
+			return out.Bytes()
+		}
+		raw := z.Raw()
+
+		switch tt {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			// Token() 會就地將標籤與屬性名稱轉為小寫，先保留原始內容
+			raw = bytes.Clone(raw)
+			tok := z.Token()
+			if r.rewriteAttrs(&tok) {
+				out.WriteString(tok.String())
+			} else {
+				out.Write(raw)
+			}
+			if tok.Data == "head" && r.injectBase {
+				out.WriteString(`<base href="` + html.EscapeString(r.location.prefix+"/") + `">`)
+			}
+			inStyle = tok.Data == "style" && tt == html.StartTagToken
+		case html.TextToken:
+			if inStyle && r.css {
+				out.Write(r.rewriteCSS(raw, true))
+			} else {
+				out.Write(raw)
+			}
+		case html.EndTagToken:
+			inStyle = false
+			out.Write(raw)
+		default:
+			out.Write(raw)
+		}
+	}
+}
+
+// rewriteAttrs 改寫標籤的 href、src、action 屬性，回傳是否有變更
+func (r *bodyRewriter) rewriteAttrs(tok *html.Token) bool {
+	changed := false
+	for i, attr := range tok.Attr {
+		if attr.Namespace != "" || (attr.Key != "href" && attr.Key != "src" && attr.Key != "action") {
+			continue
+		}
+		if rewritten := r.rewriteURL(attr.Val, true); rewritten != attr.Val {
+			tok.Attr[i].Val = rewritten
+			changed = true
+		}
+	}
+	return changed
+}
+
+// rewriteCSS 改寫 url() 與 @import 中的網址。inDocument 表示位於 HTML 的 <style> 中，相對網址依文件網址解析；
+// 獨立的 CSS 檔案中相對網址是相對於 CSS 檔案本身，不受 <base> 影響，不需改寫。
+func (r *bodyRewriter) rewriteCSS(css []byte, inDocument bool) []byte {
+	replace := func(pattern *regexp.Regexp, urlGroup int) func([]byte) []byte {
+		return func(match []byte) []byte {
+			groups := pattern.FindSubmatch(match)
+			value := string(groups[urlGroup])
+			rewritten := r.rewriteURL(value, inDocument)
+			if rewritten == value {
+				return match
+			}
+			var out []byte
+			for i := 1; i < len(groups); i++ {
+				if i == urlGroup {
+					out = append(out, rewritten...)
+				} else {
+					out = append(out, groups[i]...)
+				}
+			}
+			return out
+		}
+	}
+	css = cssURLPattern.ReplaceAllFunc(css, replace(cssURLPattern, 3))
+	return cssImportPattern.ReplaceAllFunc(css, replace(cssImportPattern, 3))
+}
+
+// rewriteURL 改寫指向後端的網址；插入 <base> 時，文件中的相對網址改為依目前網址解析後的絕對路徑
+func (r *bodyRewriter) rewriteURL(value string, inDocument bool) string {
+	trimmed := strings.TrimSpace(value)
+	u, err := url.Parse(trimmed)
+	if err != nil {
+		return value
+	}
+	if u.Scheme != "" || u.Host != "" || strings.HasPrefix(u.Path, "/") {
+		return r.location.rewriteURL(trimmed)
+	}
+	if !inDocument || !r.injectBase {
+		return value
+	}
+
+	resolved := r.document.ResolveReference(u)
+	rewritten := resolved.EscapedPath()
+	if resolved.RawQuery != "" || resolved.ForceQuery {
+		rewritten += "?" + resolved.RawQuery
+	}
+	if resolved.Fragment != "" {
+		rewritten += "#" + resolved.EscapedFragment()
+	}
+	return rewritten
+}
+
+// decodeBody 依 Content-Encoding 解壓縮，不支援的編碼（或多重編碼）回傳 false
+func decodeBody(body []byte, encoding string) ([]byte, bool) {
+	var reader io.Reader
+	switch encoding {
+	case "", "identity":
+		return body, true
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, false
+		}
+		reader = zr
+	case "deflate":
+		zr, err := zlib.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, false
+		}
+		reader = zr
+	case "br":
+		reader = brotli.NewReader(bytes.NewReader(body))
+	default:
+		return nil, false
+	}
+
+	decoded, err := io.ReadAll(io.LimitReader(reader, maxRewriteBodySize+1))
+	if err != nil || int64(len(decoded)) > maxRewriteBodySize {
+		return nil, false
+	}
+	return decoded, true
+}
+
+// encodeBody 以原本的 Content-Encoding 重新壓縮
+func encodeBody(body []byte, encoding string) ([]byte, error) {
+	var buf bytes.Buffer
+	var writer io.WriteCloser
+	switch encoding {
+	case "", "identity":
+		return body, nil
+	case "gzip", "x-gzip":
+		writer = gzip.NewWriter(&buf)
+	case "deflate":
+		writer = zlib.NewWriter(&buf)
+	case "br":
+		writer = brotli.NewWriter(&buf)
+	}
+	if _, err := writer.Write(body); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// respondReadError 回應讀取後端 body 失敗
+func respondReadError(c *gin.Context, err error) {
+	if isTimeout(err) {
+		c.JSON(http.StatusGatewayTimeout, gin.H{"error": "代理請求逾時", "details": upstreamErrorDetails(err)})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "讀取代理響應失敗"})
+}
@@ -0,0 +1,62 @@
+package services
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"infra-manager/models"
+)
+
+// 固定長度的 HTML 回應改寫網址；chunked 與超過緩衝上限的回應不讀入記憶體，原樣轉發
+func TestRewriteBodySkipsStreamedResponses(t *testing.T) {
+	const page = `<html><head></head><body><a href="/docs">docs</a></body></html>`
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if r.URL.Path == "/chunked" {
+			io.WriteString(w, page)
+			w.(http.Flusher).Flush()
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(page)))
+		io.WriteString(w, page)
+	}))
+	defer upstream.Close()
+
+	tests := []struct {
+		name        string
+		path        string
+		bufferLimit int64
+		rewritten   bool
+	}{
+		{"固定長度", "/fixed", 0, true},
+		{"chunked", "/chunked", 0, false},
+		{"超過緩衝上限", "/fixed", 16, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := newTestService(upstream.URL)
+			service.RewriteBody = models.RewriteBodyHTML
+			service.BodyLimits.MaxBufferedResponseBytes = tt.bufferLimit
+			proxy := newTestProxy(t, service)
+
+			resp, err := http.Get(proxy.URL + "/use/test" + tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			rewritten := strings.Contains(string(body), `href="/use/test/docs"`)
+			if rewritten != tt.rewritten {
+				t.Errorf("改寫結果為 %v，預期 %v：%s", rewritten, tt.rewritten, body)
+			}
+			if !tt.rewritten && string(body) != page {
+				t.Errorf("未改寫的回應應與後端相同，得到 %s", body)
+			}
+		})
+	}
+}
@@ -0,0 +1,652 @@
+package services
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"infra-manager/config"
+	"infra-manager/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 回應快取的結果，記錄於存取紀錄並以 X-Cache 標頭回傳給用戶端
+const (
+	CacheHit         = "HIT"         // 以快取中仍有效的回應回傳，未連線後端
+	CacheMiss        = "MISS"        // 快取中沒有可用的回應，已轉發給後端
+	CacheRevalidated = "REVALIDATED" // 快取已過期，後端以 304 確認內容未變更
+	CacheBypass      = "BYPASS"      // 請求不適用快取（非 GET/HEAD、Range、協定升級或 Cache-Control: no-store）
+)
+
+// cacheableStatuses 是可以快取的回應狀態碼（RFC 9110 預設可快取的狀態碼，不含 206）
+var cacheableStatuses = map[int]bool{
+	http.StatusOK: true, http.StatusNonAuthoritativeInfo: true, http.StatusNoContent: true,
+	http.StatusMultipleChoices: true, http.StatusMovedPermanently: true, http.StatusPermanentRedirect: true,
+	http.StatusNotFound: true, http.StatusMethodNotAllowed: true, http.StatusGone: true,
+	http.StatusRequestURITooLong: true, http.StatusNotImplemented: true,
+}
+
+// responseCache 是所有服務共用的回應快取，總大小超過 RESPONSE_CACHE_MAX_BYTES 時淘汰最久未使用的項目
+var responseCache = newResponseCacheStore(int64(config.Int("RESPONSE_CACHE_MAX_BYTES", 64<<20)))
+
+// cacheEntry 是一筆快取的回應，建立後不再修改（重新驗證時以新的項目取代）
+type cacheEntry struct {
+	key       string
+	serviceID uint
+	path      string            // 服務內的請求路徑，供依前綴清除
+	status    int               // 後端回應的狀態碼
+	header    http.Header       // 後端回應的標頭（尚未改寫）
+	body      []byte            // 後端回應的 body（尚未改寫）
+	source    *url.URL          // 回應來源的後端網址，網頁應用模式與 body 改寫時使用
+	vary      map[string]string // Vary 列出的請求標頭與儲存時的值，值不同的請求不使用此項目
+	date      time.Time         // 回應產生的時間（已扣除後端回報的 Age），用於計算 Age 標頭
+	expires   time.Time         // 有效期限，之後需向後端重新驗證
+	size      int64
+	elem      *list.Element
+}
+
+// cacheCounters 是單一服務的快取統計
+type cacheCounters struct {
+	entries     int
+	size        int64
+	hits        int64
+	misses      int64
+	revalidated int64
+	bypassed    int64
+	stores      int64
+	evictions   int64
+}
+
+type responseCacheStore struct {
+	mu       sync.Mutex
+	maxBytes int64
+	size     int64
+	lru      *list.List // 最近使用的項目在前
+	entries  map[string]*cacheEntry
+	counters map[uint]*cacheCounters
+}
+
+// ResponseCacheStats 是回應快取的統計資訊
+type ResponseCacheStats struct {
+	MaxBytes  int64               `json:"max_bytes"`
+	SizeBytes int64               `json:"size_bytes"`
+	Entries   int                 `json:"entries"`
+	Services  []ServiceCacheStats `json:"services"`
+}
+
+// ServiceCacheStats 是單一服務的回應快取統計
+type ServiceCacheStats struct {
+	ServiceID   uint  `json:"service_id"`
+	Entries     int   `json:"entries"`
+	SizeBytes   int64 `json:"size_bytes"`
+	Hits        int64 `json:"hits"`
+	Misses      int64 `json:"misses"`
+	Revalidated int64 `json:"revalidated"`
+	Bypassed    int64 `json:"bypassed"`
+	Stores      int64 `json:"stores"`
+	Evictions   int64 `json:"evictions"`
+}
+
+func newResponseCacheStore(maxBytes int64) *responseCacheStore {
+	return &responseCacheStore{
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  make(map[string]*cacheEntry),
+		counters: make(map[uint]*cacheCounters),
+	}
+}
+
+// cacheLookup 是單一請求使用快取的狀態
+type cacheLookup struct {
+	service    models.Service
+	key        string
+	path       string
+	invalidate bool        // 不安全的方法（POST、PUT 等），成功後清除同一路徑的快取
+	fresh      *cacheEntry // 仍有效、可直接回傳的項目
+	stale      *cacheEntry // 已過期或用戶端要求重新驗證、且有驗證器的項目
+	client     http.Header // 用戶端原本的條件標頭，重新驗證時暫時以快取項目的驗證器取代
+}
+
+// lookupCache 在服務啟用快取時查詢快取，p 為服務內的請求路徑。回傳 nil 表示此請求不使用快取。
+// GET/HEAD 的可快取回應（依 Cache-Control、Expires、Vary）存入快取，過期時以 ETag/Last-Modified 向後端重新驗證；
+// 不安全的方法成功後清除同一路徑的快取。
+func lookupCache(c *gin.Context, service models.Service, p string) *cacheLookup {
+	if !service.Cache.Enabled {
+		return nil
+	}
+	r := c.Request
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		c.Set("cacheStatus", CacheBypass)
+		responseCache.record(service.ID, CacheBypass)
+		if isSafeMethod(r.Method) {
+			return nil
+		}
+		return &cacheLookup{service: service, path: p, invalidate: true}
+	}
+
+	directives := parseCacheControl(r.Header)
+	if _, ok := directives["no-store"]; ok || isUpgradeRequest(r) || r.Header.Get("Range") != "" {
+		c.Set("cacheStatus", CacheBypass)
+		responseCache.record(service.ID, CacheBypass)
+		return nil
+	}
+
+	lookup := &cacheLookup{service: service, key: cacheKey(c, service, p), path: p}
+	entry := responseCache.get(lookup.key, r.Header)
+	if entry == nil {
+		return lookup
+	}
+
+	now := time.Now()
+	_, noCache := directives["no-cache"]
+	noCache = noCache || strings.Contains(strings.ToLower(r.Header.Get("Pragma")), "no-cache")
+	if maxAge, ok := directives["max-age"]; ok {
+		if seconds, err := strconv.Atoi(maxAge); err == nil && now.Sub(entry.date) > time.Duration(seconds)*time.Second {
+			noCache = true
+		}
+	}
+	switch {
+	case !noCache && now.Before(entry.expires):
+		lookup.fresh = entry
+	case entry.header.Get("Etag") != "" || entry.header.Get("Last-Modified") != "":
+		lookup.stale = entry
+	}
+	return lookup
+}
+
+// cacheKey 以服務、路徑、查詢字串與服務指定的請求標頭組成快取鍵。
+// 服務傳遞使用者身分時，後端可能依身分產生不同內容，因此每個使用者分開快取。
+func cacheKey(c *gin.Context, service models.Service, p string) string {
+	var key strings.Builder
+	fmt.Fprintf(&key, "%d\n%s\n%s", service.ID, p, c.Request.URL.RawQuery)
+	for _, name := range service.Cache.KeyHeaders {
+		key.WriteString("\n" + strings.Join(c.Request.Header.Values(name), ","))
+	}
+	if service.Identity != "" {
+		if token, ok := c.Get("token"); ok {
+			fmt.Fprintf(&key, "\nuser:%d", token.(models.Token).UserID)
+		}
+	}
+	return key.String()
+}
+
+// serve 以仍有效的快取項目回應
+func (l *cacheLookup) serve(c *gin.Context) *http.Response {
+	c.Set("cacheStatus", CacheHit)
+	responseCache.record(l.service.ID, CacheHit)
+	return l.fresh.response(c.Request, time.Now())
+}
+
+// prepare 在轉發前，以快取項目的 ETag 與 Last-Modified 向後端發出條件請求
+func (l *cacheLookup) prepare(r *http.Request) {
+	if l == nil || l.stale == nil {
+		return
+	}
+	l.client = http.Header{}
+	for _, name := range []string{"If-None-Match", "If-Modified-Since"} {
+		if values := r.Header.Values(name); len(values) > 0 {
+			l.client[name] = values
+		}
+		r.Header.Del(name)
+	}
+	if etag := l.stale.header.Get("Etag"); etag != "" {
+		r.Header.Set("If-None-Match", etag)
+	}
+	if lastModified := l.stale.header.Get("Last-Modified"); lastModified != "" {
+		r.Header.Set("If-Modified-Since", lastModified)
+	}
+}
+
+// finish 處理後端的回應：304 時更新並回傳快取項目，可快取的回應讀入 body 後儲存；
+// 不安全的方法成功後清除同一路徑的快取。回傳要轉發給用戶端的回應。
+func (l *cacheLookup) finish(c *gin.Context, resp *http.Response) (*http.Response, error) {
+	if l == nil {
+		return resp, nil
+	}
+	if l.invalidate {
+		if resp.StatusCode < http.StatusBadRequest {
+			responseCache.purge(l.service.ID, l.path, true)
+		}
+		return resp, nil
+	}
+
+	r := c.Request
+	if l.stale != nil {
+		// 還原用戶端原本的條件標頭，用於判斷是否回應 304
+		r.Header.Del("If-None-Match")
+		r.Header.Del("If-Modified-Since")
+		for name, values := range l.client {
+			r.Header[name] = values
+		}
+
+		if resp.StatusCode == http.StatusNotModified {
+			resp.Body.Close()
+			entry := l.stale.revalidated(resp, l.service, time.Now())
+			if entry != nil {
+				responseCache.put(entry)
+			} else {
+				// 後端的新標頭不再允許快取，此次仍以更新後的內容回應
+				responseCache.remove(l.stale)
+				entry = l.stale.withHeader(resp.Header)
+			}
+			c.Set("cacheStatus", CacheRevalidated)
+			responseCache.record(l.service.ID, CacheRevalidated)
+			return entry.response(r, time.Now()), nil
+		}
+	}
+
+	c.Set("cacheStatus", CacheMiss)
+	responseCache.record(l.service.ID, CacheMiss)
+	if r.Method != http.MethodGet {
+		return resp, nil
+	}
+
+	now := time.Now()
+	lifetime, ok := responseLifetime(resp, l.service, r.Header.Get("Authorization") != "", now)
+	if !ok {
+		return resp, nil
+	}
+	// 快取的回應需完整讀入，上限不超過服務的回應緩衝上限，較大的回應照常串流轉發
+	limit := min(l.service.Cache.EntryLimit(), responseBufferLimit(l.service))
+	if resp.ContentLength > limit {
+		return resp, nil
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > limit {
+		// 過大的回應不快取，將已讀取的部分與剩餘內容串接後照常轉發
+		resp.Body = readCloser{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	header := resp.Header.Clone()
+	removeHopHeaders(header)
+	entry := &cacheEntry{
+		key:       l.key,
+		serviceID: l.service.ID,
+		path:      l.path,
+		status:    resp.StatusCode,
+		header:    header,
+		body:      body,
+		source:    resp.Request.URL,
+		vary:      varyValues(resp.Header, r.Header),
+		date:      now.Add(-responseAge(resp.Header)),
+		expires:   now.Add(lifetime),
+	}
+	responseCache.put(entry)
+
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	return resp, nil
+}
+
+// readCloser 讀取 Reader，關閉時關閉原本的 body
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// responseLifetime 依回應的 Cache-Control、Expires 與 Age 計算有效期限，回傳 false 表示不可儲存。
+// 後端未指定有效期限時使用服務的預設快取時間；有效期限為 0 但有 ETag 或 Last-Modified 的回應仍會儲存，每次使用前重新驗證。
+func responseLifetime(resp *http.Response, service models.Service, authorized bool, now time.Time) (time.Duration, bool) {
+	if !cacheableStatuses[resp.StatusCode] || resp.Header.Get("Set-Cookie") != "" {
+		return 0, false
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType == "text/event-stream" || strings.HasPrefix(mediaType, "multipart/") {
+		return 0, false
+	}
+	for _, name := range headerList(resp.Header, "Vary") {
+		if name == "*" {
+			return 0, false
+		}
+	}
+
+	directives := parseCacheControl(resp.Header)
+	if _, ok := directives["no-store"]; ok {
+		return 0, false
+	}
+	if _, ok := directives["private"]; ok {
+		return 0, false
+	}
+	if authorized {
+		// 帶有 Authorization 的請求，只有後端明確允許共用快取時才儲存（RFC 9111 3.5）
+		_, public := directives["public"]
+		_, sMaxAge := directives["s-maxage"]
+		_, mustRevalidate := directives["must-revalidate"]
+		if !public && !sMaxAge && !mustRevalidate {
+			return 0, false
+		}
+	}
+
+	var lifetime time.Duration
+	if seconds, ok := directiveSeconds(directives, "s-maxage"); ok {
+		lifetime = seconds
+	} else if seconds, ok := directiveSeconds(directives, "max-age"); ok {
+		lifetime = seconds
+	} else if expires := resp.Header.Get("Expires"); expires != "" {
+		// 無效的 Expires（例如 0）視為已過期
+		if t, err := http.ParseTime(expires); err == nil {
+			date := now
+			if d, err := http.ParseTime(resp.Header.Get("Date")); err == nil {
+				date = d
+			}
+			lifetime = t.Sub(date)
+		}
+	} else {
+		lifetime = service.Cache.DefaultTTL()
+	}
+	if _, ok := directives["no-cache"]; ok {
+		lifetime = 0
+	}
+	lifetime -= responseAge(resp.Header)
+
+	if lifetime <= 0 {
+		if resp.Header.Get("Etag") == "" && resp.Header.Get("Last-Modified") == "" {
+			return 0, false
+		}
+		lifetime = 0
+	}
+	return lifetime, true
+}
+
+// revalidated 以後端 304 回應的標頭更新快取項目（RFC 9111 4.3.4），回傳新的項目；
+// 更新後不可再儲存時回傳 nil
+func (e *cacheEntry) revalidated(resp *http.Response, service models.Service, now time.Time) *cacheEntry {
+	updated := e.withHeader(resp.Header)
+	check := &http.Response{StatusCode: updated.status, Header: updated.header}
+	lifetime, ok := responseLifetime(check, service, false, now)
+	if !ok {
+		return nil
+	}
+	updated.date = now.Add(-responseAge(resp.Header))
+	updated.expires = now.Add(lifetime)
+	return updated
+}
+
+// withHeader 回傳以 header 中的欄位覆寫後的項目副本（不含 body 長度與逐跳標頭）
+func (e *cacheEntry) withHeader(header http.Header) *cacheEntry {
+	updated := *e
+	updated.header = e.header.Clone()
+	updated.elem = nil
+	for name, values := range header {
+		switch name {
+		case "Content-Length", "Content-Encoding", "Transfer-Encoding", "Connection", "Keep-Alive", "Age":
+			continue
+		}
+		updated.header[name] = values
+	}
+	return &updated
+}
+
+// response 以快取項目建立回應。用戶端的條件請求與項目相符時回應 304。
+func (e *cacheEntry) response(r *http.Request, now time.Time) *http.Response {
+	header := e.header.Clone()
+	header.Set("Age", strconv.Itoa(int(max(now.Sub(e.date), 0).Seconds())))
+	resp := &http.Response{
+		StatusCode:    e.status,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(e.body)),
+		ContentLength: int64(len(e.body)),
+		Request:       &http.Request{Method: r.Method, URL: e.source, Header: http.Header{}},
+	}
+	if e.status == http.StatusOK && notModified(r.Header, header) {
+		resp.StatusCode = http.StatusNotModified
+		resp.Body = http.NoBody
+		resp.ContentLength = 0
+		header.Del("Content-Length")
+	}
+	return resp
+}
+
+// notModified 依 If-None-Match（優先）或 If-Modified-Since 判斷用戶端的版本是否仍有效
+func notModified(request, response http.Header) bool {
+	if ifNoneMatch := request.Get("If-None-Match"); ifNoneMatch != "" {
+		etag := strings.TrimPrefix(response.Get("Etag"), "W/")
+		if etag == "" {
+			return false
+		}
+		for _, candidate := range strings.Split(ifNoneMatch, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+	since, err := http.ParseTime(request.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	lastModified, err := http.ParseTime(response.Get("Last-Modified"))
+	return err == nil && !lastModified.After(since)
+}
+
+// parseCacheControl 解析 Cache-Control 標頭，回傳小寫的指令名稱與參數
+func parseCacheControl(header http.Header) map[string]string {
+	directives := make(map[string]string)
+	for _, part := range headerList(header, "Cache-Control") {
+		name, arg, _ := strings.Cut(part, "=")
+		directives[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(arg), `"`)
+	}
+	return directives
+}
+
+func directiveSeconds(directives map[string]string, name string) (time.Duration, bool) {
+	value, ok := directives[name]
+	if !ok {
+		return 0, false
+	}
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || seconds < 0 {
+		// 無效的值視為已過期
+		return 0, true
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// responseAge 回傳後端（或上游快取）回報的 Age
+func responseAge(header http.Header) time.Duration {
+	seconds, err := strconv.ParseInt(strings.TrimSpace(header.Get("Age")), 10, 64)
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// headerList 將以逗號分隔的標頭值拆成清單
+func headerList(header http.Header, name string) []string {
+	var items []string
+	for _, value := range header.Values(name) {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
+
+// varyValues 記錄回應 Vary 列出的請求標頭在此請求中的值
+func varyValues(response, request http.Header) map[string]string {
+	names := headerList(response, "Vary")
+	if len(names) == 0 {
+		return nil
+	}
+	values := make(map[string]string, len(names))
+	for _, name := range names {
+		values[http.CanonicalHeaderKey(name)] = strings.Join(request.Values(name), ",")
+	}
+	return values
+}
+
+// matchesVary 判斷請求的標頭是否與快取項目儲存時相同
+func (e *cacheEntry) matchesVary(request http.Header) bool {
+	for name, value := range e.vary {
+		if strings.Join(request.Values(name), ",") != value {
+			return false
+		}
+	}
+	return true
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions || method == http.MethodTrace
+}
+
+// get 取得快取項目；Vary 列出的請求標頭與儲存時不同時視為沒有快取
+func (s *responseCacheStore) get(key string, request http.Header) *cacheEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	if !ok || !entry.matchesVary(request) {
+		return nil
+	}
+	s.lru.MoveToFront(entry.elem)
+	return entry
+}
+
+// put 儲存快取項目，取代相同鍵的項目，並淘汰最久未使用的項目直到總大小不超過上限
+func (s *responseCacheStore) put(entry *cacheEntry) {
+	entry.size = int64(len(entry.key) + len(entry.body) + 256)
+	for name, values := range entry.header {
+		for _, value := range values {
+			entry.size += int64(len(name) + len(value))
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, ok := s.entries[entry.key]; ok {
+		s.removeLocked(existing)
+	}
+	if entry.size > s.maxBytes {
+		return
+	}
+	entry.elem = s.lru.PushFront(entry)
+	s.entries[entry.key] = entry
+	s.size += entry.size
+	counters := s.countersLocked(entry.serviceID)
+	counters.entries++
+	counters.size += entry.size
+	counters.stores++
+
+	for s.size > s.maxBytes {
+		oldest := s.lru.Back().Value.(*cacheEntry)
+		s.removeLocked(oldest)
+		s.countersLocked(oldest.serviceID).evictions++
+	}
+}
+
+// remove 移除快取項目（已被取代的項目不受影響）
+func (s *responseCacheStore) remove(entry *cacheEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if current, ok := s.entries[entry.key]; ok && current == entry {
+		s.removeLocked(current)
+	}
+}
+
+func (s *responseCacheStore) removeLocked(entry *cacheEntry) {
+	s.lru.Remove(entry.elem)
+	delete(s.entries, entry.key)
+	s.size -= entry.size
+	counters := s.countersLocked(entry.serviceID)
+	counters.entries--
+	counters.size -= entry.size
+}
+
+// purge 清除服務的快取項目，serviceID 為 0 表示所有服務。exact 為 true 時只清除路徑與 p 相同的項目，
+// 否則清除路徑以 p 開頭的項目（p 為空字串表示全部）。回傳清除的項目數。
+func (s *responseCacheStore) purge(serviceID uint, p string, exact bool) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	for _, entry := range s.entries {
+		if serviceID != 0 && entry.serviceID != serviceID {
+			continue
+		}
+		if exact && entry.path != p || !exact && !strings.HasPrefix(entry.path, p) {
+			continue
+		}
+		s.removeLocked(entry)
+		count++
+	}
+	return count
+}
+
+func (s *responseCacheStore) record(serviceID uint, status string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counters := s.countersLocked(serviceID)
+	switch status {
+	case CacheHit:
+		counters.hits++
+	case CacheMiss:
+		counters.misses++
+	case CacheRevalidated:
+		counters.revalidated++
+	case CacheBypass:
+		counters.bypassed++
+	}
+}
+
+func (s *responseCacheStore) countersLocked(serviceID uint) *cacheCounters {
+	counters, ok := s.counters[serviceID]
+	if !ok {
+		counters = &cacheCounters{}
+		s.counters[serviceID] = counters
+	}
+	return counters
+}
+
+// PurgeResponseCache 清除服務（serviceID 為 0 表示所有服務）中路徑以 prefix 開頭的快取回應，回傳清除的項目數
+func PurgeResponseCache(serviceID uint, prefix string) int {
+	return responseCache.purge(serviceID, prefix, false)
+}
+
+// GetResponseCacheStats 回傳回應快取的大小與各服務的命中統計，依服務 ID 排序
+func GetResponseCacheStats() ResponseCacheStats {
+	s := responseCache
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := ResponseCacheStats{
+		MaxBytes:  s.maxBytes,
+		SizeBytes: s.size,
+		Entries:   len(s.entries),
+		Services:  make([]ServiceCacheStats, 0, len(s.counters)),
+	}
+	for serviceID, counters := range s.counters {
+		stats.Services = append(stats.Services, ServiceCacheStats{
+			ServiceID:   serviceID,
+			Entries:     counters.entries,
+			SizeBytes:   counters.size,
+			Hits:        counters.hits,
+			Misses:      counters.misses,
+			Revalidated: counters.revalidated,
+			Bypassed:    counters.bypassed,
+			Stores:      counters.stores,
+			Evictions:   counters.evictions,
+		})
+	}
+	sort.Slice(stats.Services, func(i, j int) bool { return stats.Services[i].ServiceID < stats.Services[j].ServiceID })
+	return stats
+}
@@ -0,0 +1,196 @@
+package services
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"infra-manager/models"
+)
+
+// 熔斷中拒絕請求的原因，記錄於 AccessLog.RejectReason
+const RejectCircuitOpen = "circuit_open"
+
+// 熔斷器狀態
+const (
+	CircuitClosed   = "closed"    // 正常轉發
+	CircuitOpen     = "open"      // 熔斷中，直接回傳 503
+	CircuitHalfOpen = "half_open" // 熔斷時間已過，允許少量試探請求
+)
+
+// circuitBreaker 是單一服務的熔斷器
+type circuitBreaker struct {
+	mu          sync.Mutex
+	serviceID   uint
+	serviceName string
+	config      models.CircuitBreaker
+	state       string
+	failures    int       // 連續失敗次數
+	openedAt    time.Time // 最近一次熔斷的時間
+	halfOpenAt  time.Time // 進入試探期間的時間
+	trials      int       // 試探期間已放行的請求數
+	totalOpens  int64
+	rejected    int64
+}
+
+// CircuitBreakerStatus 是熔斷器的狀態
+type CircuitBreakerStatus struct {
+	ServiceID           uint                  `json:"service_id"`
+	ServiceName         string                `json:"service_name"`
+	Config              models.CircuitBreaker `json:"config"`
+	State               string                `json:"state"`
+	ConsecutiveFailures int                   `json:"consecutive_failures"`
+	OpenedAt            *time.Time            `json:"opened_at"`
+	RetryAt             *time.Time            `json:"retry_at"` // 熔斷中時，開始允許試探請求的時間
+	TotalOpens          int64                 `json:"total_opens"`
+	Rejected            int64                 `json:"rejected"`
+}
+
+var breakers = struct {
+	sync.Mutex
+	byService map[uint]*circuitBreaker
+}{byService: make(map[uint]*circuitBreaker)}
+
+// breakerFor 取得服務的熔斷器，並套用最新的設定
+func breakerFor(service models.Service) *circuitBreaker {
+	breakers.Lock()
+	defer breakers.Unlock()
+
+	b, ok := breakers.byService[service.ID]
+	if !ok {
+		b = &circuitBreaker{serviceID: service.ID, state: CircuitClosed}
+		breakers.byService[service.ID] = b
+	}
+
+	b.mu.Lock()
+	b.serviceName = service.Name
+	if b.config != service.CircuitBreaker {
+		b.config = service.CircuitBreaker
+		// 停用熔斷時恢復正常轉發
+		if !b.config.Enabled() {
+			b.reset()
+		}
+	}
+	b.mu.Unlock()
+	return b
+}
+
+// allow 判斷請求是否可轉發；熔斷中時回傳距離允許試探請求的時間
+func (b *circuitBreaker) allow(now time.Time) (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.config.Enabled() {
+		return true, 0
+	}
+
+	if b.state == CircuitOpen {
+		retryAt := b.openedAt.Add(b.config.OpenDuration())
+		if now.Before(retryAt) {
+			b.rejected++
+			return false, retryAt.Sub(now)
+		}
+		b.state = CircuitHalfOpen
+		b.halfOpenAt = now
+		b.trials = 0
+	}
+
+	if b.state == CircuitHalfOpen {
+		// 試探請求遲遲未回報結果（例如未送出即中斷）時，經過一個熔斷週期後再放行新的試探
+		if b.trials >= b.config.Trials() && !now.Before(b.halfOpenAt.Add(b.config.OpenDuration())) {
+			b.halfOpenAt = now
+			b.trials = 0
+		}
+		if b.trials >= b.config.Trials() {
+			b.rejected++
+			return false, time.Second
+		}
+		b.trials++
+	}
+	return true, 0
+}
+
+// record 回報一次轉發的結果
+func (b *circuitBreaker) record(success bool, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.config.Enabled() {
+		return
+	}
+
+	if success {
+		b.reset()
+		return
+	}
+
+	b.failures++
+	if b.state == CircuitHalfOpen || (b.state == CircuitClosed && b.failures >= b.config.FailureThreshold) {
+		b.state = CircuitOpen
+		b.openedAt = now
+		b.totalOpens++
+	}
+}
+
+// reset 恢復為正常狀態。呼叫前需持有鎖。
+func (b *circuitBreaker) reset() {
+	b.state = CircuitClosed
+	b.failures = 0
+	b.trials = 0
+}
+
+func (b *circuitBreaker) status() CircuitBreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	status := CircuitBreakerStatus{
+		ServiceID:           b.serviceID,
+		ServiceName:         b.serviceName,
+		Config:              b.config,
+		State:               b.state,
+		ConsecutiveFailures: b.failures,
+		TotalOpens:          b.totalOpens,
+		Rejected:            b.rejected,
+	}
+	if !b.openedAt.IsZero() {
+		openedAt := b.openedAt
+		status.OpenedAt = &openedAt
+		if b.state == CircuitOpen {
+			retryAt := openedAt.Add(b.config.OpenDuration())
+			status.RetryAt = &retryAt
+		}
+	}
+	return status
+}
+
+// GetCircuitBreakers 回傳所有已建立的熔斷器狀態，依服務 ID 排序
+func GetCircuitBreakers() []CircuitBreakerStatus {
+	breakers.Lock()
+	list := make([]*circuitBreaker, 0, len(breakers.byService))
+	for _, b := range breakers.byService {
+		list = append(list, b)
+	}
+	breakers.Unlock()
+
+	statuses := make([]CircuitBreakerStatus, 0, len(list))
+	for _, b := range list {
+		statuses = append(statuses, b.status())
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].ServiceID < statuses[j].ServiceID })
+	return statuses
+}
+
+// ResetCircuitBreaker 手動將服務的熔斷器恢復為正常狀態，回傳該服務是否有熔斷器
+func ResetCircuitBreaker(serviceID uint) bool {
+	breakers.Lock()
+	b, ok := breakers.byService[serviceID]
+	breakers.Unlock()
+	if !ok {
+		return false
+	}
+
+	b.mu.Lock()
+	b.reset()
+	b.mu.Unlock()
+	return true
+}
@@ -0,0 +1,156 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"infra-manager/db"
+	"infra-manager/models"
+	"infra-manager/secrets"
+)
+
+// errCredentialsUnavailable 表示無法載入服務的後端憑證（例如金鑰未設定或已變更），
+// 此時不應在缺少憑證的情況下轉發請求
+var errCredentialsUnavailable = errors.New("無法載入後端憑證")
+
+// credential 是解密後的後端憑證
+type credential struct {
+	placement string
+	key       string
+	value     string
+}
+
+// credentials 以服務 ID 快取解密後的後端憑證，避免每個請求都查詢資料庫與解密。
+// 憑證新增、修改、輪替或刪除時呼叫 InvalidateCredentials。
+var credentials = struct {
+	sync.Mutex
+	byService map[uint][]credential
+	// generation 在每次捨棄快取時遞增，避免捨棄前載入的舊憑證在捨棄後才寫入快取
+	generation uint64
+}{byService: make(map[uint][]credential)}
+
+// credentialsFor 回傳服務的後端憑證。快取未命中時在鎖外查詢資料庫與解密，不阻塞其他服務的請求
+func credentialsFor(serviceID uint) ([]credential, error) {
+	credentials.Lock()
+	cached, ok := credentials.byService[serviceID]
+	generation := credentials.generation
+	credentials.Unlock()
+	if ok {
+		return cached, nil
+	}
+
+	loaded, err := loadCredentials(serviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials.Lock()
+	defer credentials.Unlock()
+	// 其他請求已先寫入時沿用，讓同一服務的請求使用相同的內容
+	if cached, ok := credentials.byService[serviceID]; ok {
+		return cached, nil
+	}
+	if credentials.generation == generation {
+		credentials.byService[serviceID] = loaded
+	}
+	return loaded, nil
+}
+
+// loadCredentials 從資料庫讀取並解密服務的後端憑證
+func loadCredentials(serviceID uint) ([]credential, error) {
+	var stored []models.ServiceSecret
+	if err := db.DB.Where("service_id = ?", serviceID).Order("id").Find(&stored).Error; err != nil {
+		return nil, err
+	}
+
+	loaded := make([]credential, 0, len(stored))
+	for _, secret := range stored {
+		value, _, err := secrets.DecryptSecret(secret.Ciphertext, secret.EncryptionContext())
+		if err != nil {
+			return nil, fmt.Errorf("無法解密服務憑證 %s: %w", secret.Name, err)
+		}
+		loaded = append(loaded, credential{placement: secret.Placement, key: secret.Key, value: value})
+	}
+	return loaded, nil
+}
+
+// InvalidateCredentials 捨棄服務已解密的憑證，下一個請求會重新載入
+func InvalidateCredentials(serviceID uint) {
+	credentials.Lock()
+	delete(credentials.byService, serviceID)
+	credentials.generation++
+	credentials.Unlock()
+}
+
+// setCredentials 將服務的後端憑證注入代理請求，取代用戶端送來的同名標頭或查詢參數
+func setCredentials(proxyReq *http.Request, serviceID uint) error {
+	loaded, err := credentialsFor(serviceID)
+	if err != nil {
+		fmt.Printf("服務 %d 的後端憑證載入失敗: %v\n", serviceID, err)
+		return errCredentialsUnavailable
+	}
+
+	for _, cred := range loaded {
+		switch cred.placement {
+		case models.SecretPlacementHeader:
+			proxyReq.Header.Set(cred.key, cred.value)
+		case models.SecretPlacementQuery:
+			proxyReq.URL.RawQuery = setQueryParam(proxyReq.URL.RawQuery, cred.key, cred.value)
+		case models.SecretPlacementBasicAuth:
+			username, password, _ := strings.Cut(cred.value, ":")
+			proxyReq.SetBasicAuth(username, password)
+		}
+	}
+	return nil
+}
+
+// setQueryParam 移除查詢字串中所有同名參數後附加新的值，其餘參數維持原本的順序與編碼
+func setQueryParam(rawQuery, key, value string) string {
+	var parts []string
+	for _, part := range strings.Split(rawQuery, "&") {
+		if part == "" {
+			continue
+		}
+		name, _, _ := strings.Cut(part, "=")
+		if decoded, err := url.QueryUnescape(name); err == nil && decoded == key {
+			continue
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(append(parts, url.QueryEscape(key)+"="+url.QueryEscape(value)), "&")
+}
+
+// ReencryptServiceSecrets 以目前的 SERVICE_SECRET_KEY 重新加密所有以舊金鑰加密的憑證（含 TLS 憑證），
+// 回傳重新加密的筆數。完成後即可從 SERVICE_SECRET_PREVIOUS_KEYS 移除舊金鑰。
+func ReencryptServiceSecrets() (int, error) {
+	var stored []models.ServiceSecret
+	if err := db.DB.Find(&stored).Error; err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, secret := range stored {
+		value, current, err := secrets.DecryptSecret(secret.Ciphertext, secret.EncryptionContext())
+		if err != nil {
+			return count, fmt.Errorf("無法解密服務 %d 的憑證 %s: %w", secret.ServiceID, secret.Name, err)
+		}
+		if current {
+			continue
+		}
+		ciphertext, err := secrets.EncryptSecret(value, secret.EncryptionContext())
+		if err != nil {
+			return count, err
+		}
+		if err := db.DB.Model(&secret).Update("ciphertext", ciphertext).Error; err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	reencrypted, err := reencryptTLSMaterial()
+	return count + reencrypted, err
+}
@@ -0,0 +1,56 @@
+package services
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"infra-manager/models"
+)
+
+// 後端連線失敗或逾時時，回應給用戶端的錯誤不應包含以查詢參數注入的後端憑證
+func TestUpstreamErrorHidesQueryCredential(t *testing.T) {
+	const secret = "upstream-secret-value"
+
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 代理等待回應標頭逾時後關閉連線
+		<-r.Context().Done()
+	}))
+	defer slow.Close()
+	refused := httptest.NewServer(http.NotFoundHandler())
+	refused.Close()
+
+	tests := []struct {
+		name   string
+		url    string
+		status int
+	}{
+		{"連線被拒", refused.URL, http.StatusBadGateway},
+		{"等待回應逾時", slow.URL, http.StatusGatewayTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := newTestService(tt.url)
+			service.Timeouts.ResponseHeaderSeconds = 1
+			credentials.Lock()
+			credentials.byService[service.ID] = []credential{{placement: models.SecretPlacementQuery, key: "api_key", value: secret}}
+			credentials.Unlock()
+			proxy := newTestProxy(t, service)
+
+			resp, err := http.Get(proxy.URL + "/use/test/items?q=1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Errorf("狀態碼 %d，預期 %d", resp.StatusCode, tt.status)
+			}
+			if strings.Contains(string(body), secret) || strings.Contains(string(body), "api_key") {
+				t.Errorf("回應不應包含後端憑證: %s", body)
+			}
+		})
+	}
+}
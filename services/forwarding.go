@@ -0,0 +1,207 @@
+package services
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"infra-manager/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// hopHeaders 是 RFC 9110 7.6.1 定義、只適用於單一連線的標頭，代理不應轉送
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection", // 非標準，但部分用戶端仍會送出
+	"TE",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// forwardingHeaders 是代理加入的轉送標頭，用戶端送來的值不直接轉送
+var forwardingHeaders = []string{"X-Forwarded-For", "X-Forwarded-Proto", "X-Forwarded-Host", "X-Forwarded-Prefix", "Forwarded"}
+
+// removeHopHeaders 移除逐跳標頭，以及 Connection 標頭中列出的其他標頭
+func removeHopHeaders(header http.Header) {
+	for _, value := range header.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				header.Del(name)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		header.Del(name)
+	}
+}
+
+// trustedProxyNetworks 是信任的代理網段（TRUSTED_PROXIES），啟動時由 SetTrustedProxies 設定
+var trustedProxyNetworks []*net.IPNet
+
+// SetTrustedProxies 設定信任的代理（IP 或 CIDR），與 gin 判斷用戶端 IP 使用的清單相同。需在開始處理請求前呼叫
+func SetTrustedProxies(proxies []string) error {
+	networks := make([]*net.IPNet, 0, len(proxies))
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return fmt.Errorf("無效的信任代理 %q", proxy)
+		}
+		networks = append(networks, network)
+	}
+	trustedProxyNetworks = networks
+	return nil
+}
+
+// forwardedByTrustedProxy 判斷請求是否經由信任的代理（TRUSTED_PROXIES）轉入，即連線的來源位址在信任的網段內。
+// 只有此時才沿用請求中既有的轉送標頭，否則以閘道觀察到的值取代，避免用戶端偽造。
+func forwardedByTrustedProxy(c *gin.Context) bool {
+	ip := net.ParseIP(c.RemoteIP())
+	if ip == nil {
+		return false
+	}
+	for _, network := range trustedProxyNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// setForwardingHeaders 依服務設定加入 X-Forwarded-* 或 RFC 7239 Forwarded 標頭
+func setForwardingHeaders(c *gin.Context, proxyReq *http.Request, service models.Service) {
+	peer := c.RemoteIP()
+	proto := "http"
+	if c.Request.TLS != nil {
+		proto = "https"
+	}
+	host := c.Request.Host
+
+	// 經由信任的代理轉入時，沿用前一層記錄的用戶端鏈與原始協定、主機。
+	// 原始協定與主機優先取自 X-Forwarded-Proto/Host，其次為 Forwarded 第一個元素的 proto/host，
+	// X-Forwarded-* 與 Forwarded 兩種標頭使用相同的值
+	var priorFor, priorForwarded string
+	if forwardedByTrustedProxy(c) {
+		priorFor = strings.Join(proxyReq.Header.Values("X-Forwarded-For"), ", ")
+		priorForwarded = strings.Join(proxyReq.Header.Values("Forwarded"), ", ")
+		proto = firstNonEmpty(proxyReq.Header.Get("X-Forwarded-Proto"), forwardedParam(priorForwarded, "proto"), proto)
+		host = firstNonEmpty(proxyReq.Header.Get("X-Forwarded-Host"), forwardedParam(priorForwarded, "host"), host)
+	}
+	for _, name := range forwardingHeaders {
+		proxyReq.Header.Del(name)
+	}
+
+	if service.SendsXForwarded() {
+		forwardedFor := peer
+		if priorFor != "" {
+			forwardedFor = priorFor + ", " + peer
+		}
+		proxyReq.Header.Set("X-Forwarded-For", forwardedFor)
+		proxyReq.Header.Set("X-Forwarded-Proto", proto)
+		proxyReq.Header.Set("X-Forwarded-Host", host)
+		// 代理路徑前綴（/use/<service> 或 /use/<service>/<token>），讓後端可以產生正確的連結
+		proxyReq.Header.Set("X-Forwarded-Prefix", c.GetString("proxyPrefix"))
+	}
+
+	if service.SendsForwarded() {
+		element := fmt.Sprintf("for=%s;host=%s;proto=%s", forwardedNode(peer), quoteForwarded(host), quoteForwarded(proto))
+		if priorForwarded != "" {
+			element = priorForwarded + ", " + element
+		}
+		proxyReq.Header.Set("Forwarded", element)
+	}
+}
+
+// forwardedParam 回傳 Forwarded 標頭第一個元素（最接近用戶端的一層）中指定參數的值，沒有時回傳空字串
+func forwardedParam(value, name string) string {
+	// 只取第一個元素，並以不在引號內的 ; 分隔參數
+	var pairs []string
+	start, quoted := 0, false
+loop:
+	for i := 0; i < len(value); i++ {
+		switch ch := value[i]; {
+		case ch == '\\' && quoted:
+			i++
+		case ch == '"':
+			quoted = !quoted
+		case ch == ';' && !quoted:
+			pairs = append(pairs, value[start:i])
+			start = i + 1
+		case ch == ',' && !quoted:
+			value = value[:i]
+			break loop
+		}
+	}
+	pairs = append(pairs, value[start:])
+
+	for _, pair := range pairs {
+		key, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || !strings.EqualFold(key, name) {
+			continue
+		}
+		return unquoteForwarded(v)
+	}
+	return ""
+}
+
+// unquoteForwarded 移除 quoteForwarded 加上的引號與跳脫字元
+func unquoteForwarded(value string) string {
+	if len(value) < 2 || value[0] != '"' || value[len(value)-1] != '"' {
+		return value
+	}
+	var b strings.Builder
+	for i := 1; i < len(value)-1; i++ {
+		if value[i] == '\\' && i+1 < len(value)-1 {
+			i++
+		}
+		b.WriteByte(value[i])
+	}
+	return b.String()
+}
+
+// firstNonEmpty 回傳第一個非空字串
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// forwardedNode 依 RFC 7239 格式化節點位址，IPv6 需加上中括號並以引號包住
+func forwardedNode(ip string) string {
+	if parsed := net.ParseIP(ip); parsed != nil && parsed.To4() == nil {
+		return `"[` + ip + `]"`
+	}
+	return ip
+}
+
+// quoteForwarded 在值包含 token 以外的字元（例如主機的冒號）時加上引號
+func quoteForwarded(value string) string {
+	for _, r := range value {
+		if !isTokenChar(r) {
+			return `"` + strings.ReplaceAll(strings.ReplaceAll(value, `\`, `\\`), `"`, `\"`) + `"`
+		}
+	}
+	return value
+}
+
+func isTokenChar(r rune) bool {
+	if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+		return true
+	}
+	return strings.ContainsRune("!#$%&'*+-.^_`|~", r)
+}
@@ -0,0 +1,143 @@
+package services
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"infra-manager/models"
+)
+
+// 信任的代理送來的 X-Forwarded-Proto/Host 即使沒有 X-Forwarded-For 也應沿用；其他來源的值一律取代
+func TestForwardingHeadersFromTrustedProxy(t *testing.T) {
+	received := make(chan http.Header, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Clone()
+		io.WriteString(w, "ok")
+	}))
+	defer upstream.Close()
+
+	tests := []struct {
+		name      string
+		trusted   []string
+		wantProto string
+		wantFor   string
+	}{
+		{"信任的代理", []string{"127.0.0.0/8"}, "https", "127.0.0.1"},
+		{"信任的代理（單一 IP）", []string{"127.0.0.1"}, "https", "127.0.0.1"},
+		{"未信任的來源", []string{"10.0.0.0/8"}, "http", "127.0.0.1"},
+		{"未設定信任的代理", nil, "http", "127.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := SetTrustedProxies(tt.trusted); err != nil {
+				t.Fatal(err)
+			}
+			defer SetTrustedProxies(nil)
+
+			proxy := newTestProxy(t, newTestService(upstream.URL))
+			req, _ := http.NewRequest(http.MethodGet, proxy.URL+"/use/test/", nil)
+			req.Header.Set("X-Forwarded-Proto", "https")
+			req.Header.Set("X-Forwarded-Host", "public.example.com")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			header := <-received
+			wantHost := "public.example.com"
+			if tt.wantProto == "http" {
+				wantHost = req.URL.Host
+			}
+			if got := header.Get("X-Forwarded-Proto"); got != tt.wantProto {
+				t.Errorf("X-Forwarded-Proto 為 %q，預期 %q", got, tt.wantProto)
+			}
+			if got := header.Get("X-Forwarded-Host"); got != wantHost {
+				t.Errorf("X-Forwarded-Host 為 %q，預期 %q", got, wantHost)
+			}
+			if got := header.Get("X-Forwarded-For"); got != tt.wantFor {
+				t.Errorf("X-Forwarded-For 為 %q，預期 %q", got, tt.wantFor)
+			}
+		})
+	}
+
+	if err := SetTrustedProxies([]string{"not-an-ip"}); err == nil {
+		t.Error("無效的信任代理應回傳錯誤")
+	}
+}
+
+// 信任的代理轉入時，Forwarded 接在前一層的值之後，proto 與 host 與 X-Forwarded-Proto/Host 使用相同的來源
+func TestForwardedFromTrustedProxy(t *testing.T) {
+	received := make(chan http.Header, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Clone()
+		io.WriteString(w, "ok")
+	}))
+	defer upstream.Close()
+
+	if err := SetTrustedProxies([]string{"127.0.0.0/8"}); err != nil {
+		t.Fatal(err)
+	}
+	defer SetTrustedProxies(nil)
+
+	const prior = `for=198.51.100.7;host="public.example.com:8443";proto=https`
+	tests := []struct {
+		name       string
+		xForwarded bool
+		wantHost   string
+	}{
+		{"X-Forwarded-* 與 Forwarded", true, "public.example.com"},
+		{"只有 Forwarded", false, "public.example.com:8443"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := newTestService(upstream.URL)
+			service.ForwardHeaders = models.ForwardHeadersBoth
+			proxy := newTestProxy(t, service)
+
+			req, _ := http.NewRequest(http.MethodGet, proxy.URL+"/use/test/", nil)
+			req.Header.Set("Forwarded", prior)
+			if tt.xForwarded {
+				req.Header.Set("X-Forwarded-For", "198.51.100.7")
+				req.Header.Set("X-Forwarded-Proto", "https")
+				req.Header.Set("X-Forwarded-Host", "public.example.com")
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			header := <-received
+			if got := header.Get("X-Forwarded-Proto"); got != "https" {
+				t.Errorf("X-Forwarded-Proto 為 %q，預期 https", got)
+			}
+			if got := header.Get("X-Forwarded-Host"); got != tt.wantHost {
+				t.Errorf("X-Forwarded-Host 為 %q，預期 %q", got, tt.wantHost)
+			}
+			want := prior + ", for=127.0.0.1;host=" + quoteForwarded(tt.wantHost) + ";proto=https"
+			if got := header.Get("Forwarded"); got != want {
+				t.Errorf("Forwarded 為 %q，預期 %q", got, want)
+			}
+		})
+	}
+}
+
+func TestForwardedParam(t *testing.T) {
+	tests := []struct {
+		value, name, want string
+	}{
+		{`for=192.0.2.1;proto=https;host=example.com`, "proto", "https"},
+		{`For="[2001:db8::1]";Host="example.com:8443", for=10.0.0.1;host=inner`, "host", "example.com:8443"},
+		{`for="a;b,c";host=example.com, host=second`, "host", "example.com"},
+		{`for=192.0.2.1, proto=https`, "proto", ""},
+		{``, "host", ""},
+	}
+	for _, tt := range tests {
+		if got := forwardedParam(tt.value, tt.name); got != tt.want {
+			t.Errorf("forwardedParam(%q, %q) = %q，預期 %q", tt.value, tt.name, got, tt.want)
+		}
+	}
+}
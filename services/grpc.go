@@ -0,0 +1,44 @@
+package services
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// isGRPC 判斷請求或回應是否為 gRPC（含 gRPC-Web）
+func isGRPC(header http.Header) bool {
+	return strings.HasPrefix(strings.ToLower(header.Get("Content-Type")), "application/grpc")
+}
+
+// acceptsTrailers 判斷用戶端是否以 TE: trailers 表示接受 trailer。TE 是逐跳標頭，
+// 但 gRPC 後端依此判斷用戶端是否支援 trailer，因此此值會轉送給後端。
+func acceptsTrailers(header http.Header) bool {
+	for _, value := range headerList(header, "Te") {
+		name, _, _ := strings.Cut(value, ";")
+		if strings.EqualFold(strings.TrimSpace(name), "trailers") {
+			return true
+		}
+	}
+	return false
+}
+
+// copyTrailers 在讀完後端回應 body 後，將後端的 trailer（例如 grpc-status、grpc-message）轉送給用戶端，
+// 並記錄 gRPC 狀態碼。只有 trailer 的 gRPC 回應（trailers-only）狀態碼位於回應標頭中。
+func copyTrailers(c *gin.Context, resp *http.Response) {
+	for key, values := range resp.Trailer {
+		if len(values) == 0 {
+			continue
+		}
+		c.Writer.Header()[http.TrailerPrefix+key] = values
+	}
+
+	status := resp.Trailer.Get("Grpc-Status")
+	if status == "" {
+		status = resp.Header.Get("Grpc-Status")
+	}
+	if status != "" {
+		c.Set("grpcStatus", status)
+	}
+}
@@ -0,0 +1,183 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"sync"
+	"time"
+
+	"infra-manager/db"
+	"infra-manager/models"
+)
+
+// 健康檢查排程的精度，以及重新從資料庫載入服務設定的間隔
+const (
+	healthCheckTick   = time.Second
+	healthCheckReload = 5 * time.Second
+)
+
+// healthChecker 定期探測啟用健康檢查之服務的每個後端目標
+type healthChecker struct {
+	stop chan struct{}
+	done chan struct{}
+	wg   sync.WaitGroup
+
+	services []models.Service
+	loadedAt time.Time
+}
+
+var checker *healthChecker
+
+// StartHealthChecker 啟動背景健康檢查
+func StartHealthChecker() {
+	hc := &healthChecker{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	checker = hc
+	go hc.run()
+}
+
+// StopHealthChecker 停止背景健康檢查並等待進行中的探測結束
+func StopHealthChecker() {
+	hc := checker
+	if hc == nil {
+		return
+	}
+	close(hc.stop)
+	<-hc.done
+}
+
+func (hc *healthChecker) run() {
+	defer close(hc.done)
+	defer hc.wg.Wait()
+
+	ticker := time.NewTicker(healthCheckTick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-hc.stop:
+			return
+		case now := <-ticker.C:
+			hc.tick(now)
+		}
+	}
+}
+
+// tick 重新載入服務設定（必要時），並對到期的服務發起探測
+func (hc *healthChecker) tick(now time.Time) {
+	if now.Sub(hc.loadedAt) >= healthCheckReload {
+		var services []models.Service
+		if err := db.DB.Where("is_active = ?", true).Find(&services).Error; err != nil {
+			fmt.Printf("健康檢查載入服務失敗: %v\n", err)
+			return
+		}
+		keep := make(map[uint]bool, len(services))
+		for _, service := range services {
+			keep[service.ID] = true
+		}
+		upstreams.prune(keep)
+		hc.services = services
+		hc.loadedAt = now
+	}
+
+	for _, service := range hc.services {
+		if !service.HealthCheck.Enabled() {
+			continue
+		}
+		pool, err := upstreams.pool(service)
+		if err != nil || now.UnixNano() < pool.nextCheck.Load() || !pool.checking.CompareAndSwap(false, true) {
+			continue
+		}
+		pool.nextCheck.Store(now.Add(service.HealthCheck.Interval()).UnixNano())
+
+		// 探測使用服務的連線池，套用相同的 TLS 與 h2c 設定
+		client, err := clientFor(service)
+		if err != nil {
+			pool.checking.Store(false)
+			continue
+		}
+
+		hc.wg.Add(1)
+		go func(name string, pool *upstreamPool, transport http.RoundTripper) {
+			defer hc.wg.Done()
+			defer pool.checking.Store(false)
+			hc.checkPool(name, pool, transport)
+		}(service.Name, pool, client.Transport)
+	}
+}
+
+// checkPool 同時探測服務的所有目標
+func (hc *healthChecker) checkPool(serviceName string, pool *upstreamPool, transport http.RoundTripper) {
+	var wg sync.WaitGroup
+	for _, target := range pool.targets {
+		wg.Add(1)
+		go func(target *upstreamTarget) {
+			defer wg.Done()
+			err := hc.probe(target, pool.healthCheck, transport)
+			target.recordCheck(serviceName, pool.healthCheck, err)
+		}(target)
+	}
+	wg.Wait()
+}
+
+// probe 對目標的探測路徑發送 GET 請求，2xx 或 3xx 視為成功
+func (hc *healthChecker) probe(target *upstreamTarget, check models.HealthCheck, transport http.RoundTripper) error {
+	probeURL := *target.url
+	probeURL.Path = path.Join("/", probeURL.Path, check.Path)
+	probeURL.RawQuery = ""
+
+	req, err := http.NewRequest(http.MethodGet, probeURL.String(), nil)
+	if err != nil {
+		return err
+	}
+	if isUnixSocketURL(&probeURL) {
+		req.Host = unixSocketRequestHost
+	}
+	client := http.Client{
+		Transport: transport,
+		Timeout:   check.Timeout(),
+		// 健康檢查以探測路徑本身的回應為準，不跟隨重新導向
+		CheckRedirect: noFollowRedirect,
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("狀態碼 %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// recordCheck 累計連續成功或失敗次數，達到門檻時切換健康狀態
+func (t *upstreamTarget) recordCheck(serviceName string, check models.HealthCheck, err error) {
+	healthyThreshold, unhealthyThreshold := check.Thresholds()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.lastChecked = time.Now()
+	if err == nil {
+		t.successes++
+		t.failures = 0
+		t.lastError = ""
+		if !t.healthy.Load() && t.successes >= healthyThreshold {
+			t.healthy.Store(true)
+			fmt.Printf("服務 %s 的後端目標 %s 已恢復健康\n", serviceName, t.raw)
+		}
+		return
+	}
+
+	t.failures++
+	t.successes = 0
+	t.lastError = err.Error()
+	if t.healthy.Load() && t.failures >= unhealthyThreshold {
+		t.healthy.Store(false)
+		fmt.Printf("服務 %s 的後端目標 %s 健康檢查失敗，已移出輪替: %v\n", serviceName, t.raw, err)
+	}
+}
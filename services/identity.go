@@ -0,0 +1,96 @@
+package services
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"infra-manager/config"
+	"infra-manager/consts"
+	"infra-manager/models"
+	"infra-manager/secrets"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 傳遞給後端的身分標頭
+const (
+	IdentityHeaderPrefix  = "X-Infra-"
+	IdentityUserHeader    = "X-Infra-User"
+	IdentityUserIDHeader  = "X-Infra-User-ID"
+	IdentityTokenIDHeader = "X-Infra-Token-ID"
+	IdentityJWTHeader     = "X-Infra-Identity"
+)
+
+// identityJWTTTL 是身分 JWT 的有效時間（IDENTITY_JWT_TTL），nbf 另外往前推 5 秒以容忍時鐘誤差
+var identityJWTTTL = config.Duration("IDENTITY_JWT_TTL", time.Minute)
+
+const identityJWTClockSkew = 5 * time.Second
+
+// identityClaims 是身分 JWT 的內容
+type identityClaims struct {
+	Issuer    string `json:"iss"`
+	Subject   string `json:"sub"` // 使用者 ID
+	Audience  string `json:"aud"` // 服務名稱
+	IssuedAt  int64  `json:"iat"`
+	NotBefore int64  `json:"nbf"`
+	ExpiresAt int64  `json:"exp"`
+	Username  string `json:"username"`
+	TokenID   uint   `json:"token_id"`
+	ServiceID uint   `json:"service_id"`
+}
+
+// setIdentityHeaders 移除用戶端送來的所有 X-Infra-* 標頭，避免偽造身分，
+// 再依服務設定加入驗證後的使用者與Token資訊
+func setIdentityHeaders(c *gin.Context, proxyReq *http.Request, service models.Service) error {
+	for key := range proxyReq.Header {
+		if strings.HasPrefix(key, IdentityHeaderPrefix) {
+			proxyReq.Header.Del(key)
+		}
+	}
+
+	if !service.SendsIdentityHeaders() && !service.SendsIdentityJWT() {
+		return nil
+	}
+	user := c.MustGet("user").(models.User)
+	token := c.MustGet("token").(models.Token)
+
+	if service.SendsIdentityHeaders() {
+		proxyReq.Header.Set(IdentityUserHeader, headerSafe(user.Username))
+		proxyReq.Header.Set(IdentityUserIDHeader, strconv.FormatUint(uint64(user.ID), 10))
+		proxyReq.Header.Set(IdentityTokenIDHeader, strconv.FormatUint(uint64(token.ID), 10))
+	}
+
+	if service.SendsIdentityJWT() {
+		now := time.Now()
+		jwt, err := secrets.SignIdentityJWT(identityClaims{
+			Issuer:    consts.SERVICE_NAME,
+			Subject:   strconv.FormatUint(uint64(user.ID), 10),
+			Audience:  service.Name,
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Add(-identityJWTClockSkew).Unix(),
+			ExpiresAt: now.Add(identityJWTTTL).Unix(),
+			Username:  user.Username,
+			TokenID:   token.ID,
+			ServiceID: service.ID,
+		})
+		if err != nil {
+			return err
+		}
+		proxyReq.Header.Set(IdentityJWTHeader, jwt)
+	}
+	return nil
+}
+
+// headerSafe 讓使用者名稱可以安全地放入標頭：包含非 ASCII 或控制字元時改以 URL 編碼
+func headerSafe(value string) string {
+	for _, r := range value {
+		if r > unicode.MaxASCII || unicode.IsControl(r) {
+			return url.PathEscape(value)
+		}
+	}
+	return value
+}
@@ -0,0 +1,268 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"infra-manager/config"
+	"infra-manager/db"
+	"infra-manager/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 鏡像請求未送出的原因，記錄於 MirrorLog.Error
+const (
+	MirrorSkippedBodyTooLarge = "skipped_body_too_large" // 請求 body 超過上限或長度未知
+	MirrorSkippedBodyRead     = "skipped_body_read"      // 讀取請求 body 失敗
+)
+
+// MirrorIdentityHeader 標示鏡像請求，讓鏡像目標可以區分鏡像流量
+const MirrorIdentityHeader = IdentityHeaderPrefix + "Mirror"
+
+// mirrorSlots 限制同時進行的鏡像請求數，鏡像目標變慢時捨棄超出的鏡像，不影響主要請求
+var mirrorSlots = make(chan struct{}, max(config.Int("MIRROR_MAX_CONCURRENCY", 100), 1))
+
+// mirrorTransport 是所有鏡像請求共用的連線池。TLS 設定依鏡像目標的網址（系統信任的 CA、以主機名稱驗證），
+// 不使用主要服務的 CA、SNI 覆寫與用戶端憑證
+var mirrorTransport = newMirrorTransport()
+
+// mirrorDropped 是因同時進行的鏡像請求過多而捨棄的次數（自啟動以來）
+var mirrorDropped atomic.Int64
+
+// mirrorRun 是一次鏡像請求，主要回應結束後與鏡像回應比較並寫入 MirrorLog
+type mirrorRun struct {
+	log      models.MirrorLog
+	req      *http.Request
+	client   *http.Client
+	cancel   context.CancelFunc
+	start    time.Time
+	recorder *mirrorRecorder
+	primary  chan primaryResult
+}
+
+// primaryResult 是送給用戶端的主要回應摘要
+type primaryResult struct {
+	status   int
+	size     int64
+	sum      []byte
+	duration time.Duration
+}
+
+// mirrorRecorder 計算送給用戶端的回應 body 的大小與 SHA-256
+type mirrorRecorder struct {
+	gin.ResponseWriter
+	hash hash.Hash
+	size int64
+}
+
+func (w *mirrorRecorder) Write(data []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(data)
+	w.hash.Write(data[:n])
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *mirrorRecorder) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Unwrap 讓 http.ResponseController 可以取得底層的 ResponseWriter
+func (w *mirrorRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// startMirror 依服務的抽樣比例決定是否鏡像此請求。鏡像時緩衝請求 body（不超過上限），
+// 以背景 goroutine 送出鏡像請求，並開始記錄主要回應；呼叫端需在回應結束後呼叫 finish。
+// 協定升級請求不鏡像。
+func startMirror(c *gin.Context, service models.Service, route route) *mirrorRun {
+	mirror := service.Mirror
+	if !mirror.Enabled() || isUpgradeRequest(c.Request) || rand.Float64()*100 >= mirror.SamplePercent {
+		return nil
+	}
+	select {
+	case mirrorSlots <- struct{}{}:
+	default:
+		mirrorDropped.Add(1)
+		return nil
+	}
+
+	run := &mirrorRun{
+		log: models.MirrorLog{
+			ServiceID: service.ID,
+			Method:    c.Request.Method,
+			Endpoint:  c.GetString("logEndpoint"),
+			Target:    mirror.URL,
+		},
+		start:   time.Now(),
+		primary: make(chan primaryResult, 1),
+	}
+	run.recorder = &mirrorRecorder{ResponseWriter: c.Writer, hash: sha256.New()}
+	c.Writer = run.recorder
+
+	body, skip := bufferMirrorBody(c.Request, mirror.BodyLimit())
+	if skip != "" {
+		run.log.Error = skip
+		go run.run()
+		return run
+	}
+
+	target, err := parseUpstreamURL(mirror.URL)
+	if err != nil {
+		run.log.Error = err.Error()
+		go run.run()
+		return run
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), mirror.Timeout())
+	req, err := newMirrorRequest(ctx, c, service, &upstreamTarget{raw: mirror.URL, url: target, weight: 1}, route.path)
+	if err != nil {
+		cancel()
+		run.log.Error = err.Error()
+		go run.run()
+		return run
+	}
+	req.Header.Set(MirrorIdentityHeader, "1")
+	req.ContentLength = int64(len(body))
+	if len(body) > 0 {
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	run.req = req
+	run.cancel = cancel
+	run.client = &http.Client{Transport: mirrorTransport, CheckRedirect: noFollowRedirect}
+	go run.run()
+	return run
+}
+
+// newMirrorRequest 建立送往鏡像目標的請求（不含 body）。服務設定 include_credentials 時與主要請求相同；
+// 否則只複製用戶端的請求，移除用戶端送來的轉送標頭與 X-Infra-* 標頭，不加入身分資訊與後端憑證
+func newMirrorRequest(ctx context.Context, c *gin.Context, service models.Service, target *upstreamTarget, endpoint string) (*http.Request, error) {
+	if service.Mirror.IncludeCredentials {
+		return newProxyRequest(ctx, c, service, target, endpoint)
+	}
+	req, err := newUpstreamRequest(ctx, c, target, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range forwardingHeaders {
+		req.Header.Del(name)
+	}
+	for key := range req.Header {
+		if strings.HasPrefix(key, IdentityHeaderPrefix) {
+			req.Header.Del(key)
+		}
+	}
+	return req, nil
+}
+
+// newMirrorTransport 建立鏡像請求的 Transport，支援 unix socket 目標
+func newMirrorTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialUpstream(&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second})
+	transport.Proxy = bypassProxyForUnixSockets(transport.Proxy)
+	// 保留的閒置連線數與同時進行的鏡像請求上限相同
+	transport.MaxIdleConnsPerHost = cap(mirrorSlots)
+	return transport
+}
+
+// bufferMirrorBody 讀入請求 body 供鏡像請求使用，並將請求的 body 換成相同內容。
+// 長度未知或超過上限時不讀取（串流上傳與 gRPC 等雙向串流不會被阻塞），回傳略過的原因。
+func bufferMirrorBody(r *http.Request, limit int64) ([]byte, string) {
+	if r.Body == nil || r.Body == http.NoBody || r.ContentLength == 0 {
+		return nil, ""
+	}
+	if r.ContentLength < 0 || r.ContentLength > limit {
+		return nil, MirrorSkippedBodyTooLarge
+	}
+
+	buf, err := io.ReadAll(io.LimitReader(r.Body, r.ContentLength))
+	if err != nil {
+		// 讀取失敗（例如超過請求 body 上限或用戶端中斷）時交由主要請求回報相同的錯誤
+		r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(buf), r.Body))
+		return nil, MirrorSkippedBodyRead
+	}
+	r.Body = io.NopCloser(bytes.NewReader(buf))
+	return buf, ""
+}
+
+// finish 在主要回應結束後呼叫，將回應摘要交給鏡像 goroutine 比較
+func (m *mirrorRun) finish(c *gin.Context) {
+	m.primary <- primaryResult{
+		status:   c.Writer.Status(),
+		size:     m.recorder.size,
+		sum:      m.recorder.hash.Sum(nil),
+		duration: time.Since(m.start),
+	}
+}
+
+// run 送出鏡像請求並讀完回應，等待主要回應結束後比較狀態碼與 body，寫入 MirrorLog
+func (m *mirrorRun) run() {
+	defer func() { <-mirrorSlots }()
+
+	var mirrorSum []byte
+	if m.req != nil {
+		start := time.Now()
+		resp, err := m.client.Do(m.req)
+		if err != nil {
+			// 鏡像請求可能帶有以查詢參數注入的後端憑證，不將完整 URL 寫入紀錄
+			m.log.Error = redactUpstreamError(err).Error()
+		} else {
+			h := sha256.New()
+			n, err := io.Copy(h, resp.Body)
+			resp.Body.Close()
+			m.log.MirrorStatus = resp.StatusCode
+			m.log.MirrorSize = n
+			if err != nil {
+				m.log.Error = fmt.Sprintf("讀取鏡像回應失敗: %v", err)
+			} else {
+				mirrorSum = h.Sum(nil)
+			}
+		}
+		m.log.MirrorDuration = time.Since(start).Milliseconds()
+		m.cancel()
+	}
+
+	primary := <-m.primary
+	m.log.PrimaryStatus = primary.status
+	m.log.PrimarySize = primary.size
+	m.log.PrimaryDuration = primary.duration.Milliseconds()
+	m.log.StatusMatch = m.log.MirrorStatus == primary.status
+	m.log.BodyMatch = m.log.StatusMatch && mirrorSum != nil && bytes.Equal(mirrorSum, primary.sum)
+
+	if err := db.DB.Create(&m.log).Error; err != nil {
+		fmt.Printf("寫入服務 %d 的鏡像紀錄失敗: %v\n", m.log.ServiceID, err)
+	}
+}
+
+// MirrorDropped 回傳因同時進行的鏡像請求過多而捨棄的次數（自啟動以來）
+func MirrorDropped() int64 {
+	return mirrorDropped.Load()
+}
+
+// ValidateMirror 檢查流量鏡像設定
+func ValidateMirror(mirror models.Mirror) error {
+	if mirror.SamplePercent < 0 || mirror.SamplePercent > 100 {
+		return errors.New("鏡像抽樣比例需介於 0 到 100")
+	}
+	if mirror.MaxBodyBytes < 0 || mirror.TimeoutSeconds < 0 {
+		return errors.New("鏡像的 body 上限與逾時不可為負數")
+	}
+	if mirror.URL != "" {
+		if _, err := parseUpstreamURL(mirror.URL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
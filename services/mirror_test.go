@@ -0,0 +1,121 @@
+package services
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"infra-manager/db"
+	"infra-manager/models"
+)
+
+// 鏡像請求預設不帶後端憑證、身分與轉送標頭；include_credentials 時與主要請求相同
+func TestMirrorRequestCredentials(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer primary.Close()
+
+	mirrored := make(chan http.Header, 1)
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mirrored <- r.Header.Clone()
+		io.WriteString(w, "ok")
+	}))
+	defer mirror.Close()
+
+	for _, include := range []bool{false, true} {
+		service := newTestService(primary.URL)
+		service.Identity = models.IdentityHeaders
+		service.Mirror = models.Mirror{URL: mirror.URL, SamplePercent: 100, IncludeCredentials: include}
+		credentials.Lock()
+		credentials.byService[service.ID] = []credential{{placement: models.SecretPlacementHeader, key: "X-Api-Key", value: "upstream-secret"}}
+		credentials.Unlock()
+		proxy := newTestProxy(t, service)
+
+		req, _ := http.NewRequest(http.MethodGet, proxy.URL+"/use/test/items", nil)
+		req.Header.Set("X-Request-Id", "abc")
+		req.Header.Set("X-Forwarded-For", "203.0.113.9")
+		req.Header.Set(IdentityUserHeader, "spoofed")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		var header http.Header
+		select {
+		case header = <-mirrored:
+		case <-time.After(5 * time.Second):
+			t.Fatal("鏡像目標未收到請求")
+		}
+		if header.Get(MirrorIdentityHeader) != "1" || header.Get("X-Request-Id") != "abc" {
+			t.Errorf("鏡像請求應保留用戶端標頭並標示 %s，得到 %v", MirrorIdentityHeader, header)
+		}
+
+		if include {
+			if header.Get("X-Api-Key") != "upstream-secret" || header.Get(IdentityUserHeader) != "tester" || header.Get("X-Forwarded-For") == "" {
+				t.Errorf("include_credentials 時鏡像請求應與主要請求相同，得到 %v", header)
+			}
+			continue
+		}
+		for _, name := range []string{"X-Api-Key", IdentityUserHeader, IdentityTokenIDHeader, "X-Forwarded-For", "X-Forwarded-Prefix"} {
+			if value := header.Get(name); value != "" {
+				t.Errorf("鏡像請求不應包含 %s（%q）", name, value)
+			}
+		}
+	}
+}
+
+// 熔斷拒絕的請求不會送到主要後端，也不應鏡像或寫入鏡像紀錄
+func TestMirrorSkipsOpenCircuit(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer primary.Close()
+
+	var mirrored atomic.Int64
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mirrored.Add(1)
+		io.WriteString(w, "ok")
+	}))
+	defer mirror.Close()
+
+	service := newTestService(primary.URL)
+	service.CircuitBreaker = models.CircuitBreaker{FailureThreshold: 1, OpenSeconds: 60}
+	service.Mirror = models.Mirror{URL: mirror.URL, SamplePercent: 100}
+	proxy := newTestProxy(t, service)
+	breakerFor(service).record(false, time.Now())
+
+	resp, err := http.Get(proxy.URL + "/use/test/items")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("熔斷中應回傳 503，得到 %d", resp.StatusCode)
+	}
+
+	// 恢復後送出一般請求，等到它的鏡像紀錄寫入後確認熔斷拒絕的請求沒有紀錄
+	ResetCircuitBreaker(service.ID)
+	resp, err = http.Get(proxy.URL + "/use/test/items")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	var logs []models.MirrorLog
+	for deadline := time.Now().Add(5 * time.Second); len(logs) == 0 && time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if err := db.DB.Where("service_id = ?", service.ID).Find(&logs).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(logs) != 1 || logs[0].PrimaryStatus != http.StatusOK {
+		t.Fatalf("應只有一筆主要回應為 200 的鏡像紀錄，得到 %+v", logs)
+	}
+	if n := mirrored.Load(); n != 1 {
+		t.Errorf("鏡像目標應只收到 1 個請求，得到 %d", n)
+	}
+}
@@ -1,97 +1,187 @@
 package services
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"net/url"
-	"path"
-	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"infra-manager/models"
 
 	"github.com/gin-gonic/gin"
 )
 
-// ProxyRequest 代理請求並轉發至後端 service。主要行為：
-//   - 轉發原始請求（包含 method、headers 與 body），盡量直接串流請求 body 到後端。
-//   - 根據回應內容自動判斷是否以串流方式（Transfer-Encoding: chunked、Content-Length == -1、SSE/Multipart）
-//     來轉發。如果判定為串流，直接使用 io.Copy 串流回應；否則會在代理端完整讀取回應後再回傳（以便正確計算 Content-Length）。
-//   - 不會對回應 body 做 URL 或內容改寫（避免意外破壞後端回應）。
-//   - 會複製並轉發大部分標頭，但對 Set-Cookie 會移除 Domain 屬性（以利 cookie 在代理網域設定）。
-//   - 會保留 Location header 的值（不做自動改寫）。
-//   - 會為代理回應添加禁止搜尋引擎索引的 header (X-Robots-Tag) 與 Cache-Control 相關 header。
+// ProxyRequest 將通過 TokenAuth 驗證的請求轉發至服務的後端，並將回應寫回用戶端。
+// 依序處理請求 body 上限、路由規則、回應快取、熔斷、後端目標選擇、協定升級、流量鏡像與重試，
+// 各項行為的細節見負責的函式（limitRequestBody、matchRoute、lookupCache、breakerFor、pickUpstream、
+// proxyUpgrade、startMirror、doWithRetries、newProxyRequest 與 writeProxyResponse）。
 func ProxyRequest(c *gin.Context) {
 	// 從上下文中獲取數據
 	service := c.MustGet("service").(models.Service)
-	targetEndpoint, _ := c.Get("targetEndpoint")
-	targetEndpointStr, _ := targetEndpoint.(string)
+	token := c.MustGet("token").(models.Token)
 
-	// 構建目標URL
-	targetURL, err := url.Parse(service.BaseURL)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "服務URL配置錯誤"})
+	// 限制請求 body 大小，已知長度超過上限時直接回傳 413，不轉發給後端
+	if !limitRequestBody(c, service) {
 		return
 	}
 
-	// 拼接完整的目標路徑
-	targetURL.Path = path.Join(targetURL.Path, targetEndpointStr)
+	// 依序套用服務的路由規則，決定轉發的路徑與後端
+	routePath := requestRoutePath(c)
+	route := matchRoute(service.Routes, routePath)
 
-	// 複製URL查詢參數
-	targetURL.RawQuery = c.Request.URL.RawQuery
+	// 服務啟用回應快取時，仍有效的快取回應直接回傳，不需連線後端（熔斷中亦同）
+	cache := lookupCache(c, service, routePath)
+	if cache != nil && cache.fresh != nil {
+		writeProxyResponse(c, service, cache.serve(c))
+		return
+	}
 
-	// 創建新的請求
-	proxyReq, err := http.NewRequest(c.Request.Method, targetURL.String(), nil)
+	// 使用服務共用的連線池；連線與等待回應標頭的逾時由 Transport 控制
+	client, err := clientFor(service)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法載入服務的後端 TLS 設定"})
+		return
+	}
+
+	// 熔斷中的服務直接回傳 503，避免持續對故障的後端發送請求
+	breaker := breakerFor(service)
+	if ok, retryAfter := breaker.allow(time.Now()); !ok {
+		c.Header("Retry-After", strconv.Itoa(max(int(math.Ceil(retryAfter.Seconds())), 1)))
+		c.Set("rejectReason", RejectCircuitOpen)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "服務暫時無法使用，請稍後再試"})
+		return
+	}
+
+	// 第一次嘗試的後端目標；重試時重新選擇
+	upstream, err := route.pickUpstream(service, token.ID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "無法創建代理請求"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "服務URL配置錯誤"})
 		return
 	}
 
-	// 若有請求體，直接傳遞 Request body 給後端，避免將整個請求讀入記憶體
-	if c.Request.Body != nil {
+	// 協定升級（如 WebSocket）改為接管連線並雙向轉送，不套用整體逾時與重試
+	if isUpgradeRequest(c.Request) {
+		proxyReq, err := newProxyRequest(c.Request.Context(), c, service, upstream, route.path)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "無法創建代理請求"})
+			return
+		}
 		proxyReq.Body = c.Request.Body
 		proxyReq.ContentLength = c.Request.ContentLength
+		upstream.active.Add(1)
+		defer upstream.active.Add(-1)
+		proxyUpgrade(c, service, client, proxyReq)
+		breaker.record(!isFailureStatus(c.Writer.Status()), time.Now())
+		return
 	}
 
-	// 複製標頭
-	for key, values := range c.Request.Header {
-		// 略過Host標頭，因為它會被http.Client設定
-		if key != "Host" {
-			for _, value := range values {
-				proxyReq.Header.Add(key, value)
-			}
-		}
+	// 依抽樣比例複製請求送往鏡像目標，鏡像的回應只記錄，不影響用戶端
+	if mirror := startMirror(c, service, route); mirror != nil {
+		defer mirror.finish(c)
+	}
+
+	// HTTP/1 預設會在開始寫回應前讀完請求 body；代理在後端回應的同時仍在轉送請求 body，需允許同時讀寫
+	if c.Request.ProtoMajor == 1 {
+		http.NewResponseController(c.Writer).EnableFullDuplex()
+	}
+
+	// 整體逾時涵蓋重試與讀取回應 body
+	ctx := c.Request.Context()
+	if total := service.Timeouts.Total(); total > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, total)
+		defer cancel()
 	}
 
-	// 發送請求
-	client := &http.Client{}
-	proxyResp, err := client.Do(proxyReq)
+	// 快取已過期時以 ETag 與 Last-Modified 向後端重新驗證
+	cache.prepare(c.Request)
+	proxyResp, release, err := doWithRetries(ctx, c, client, service, route, upstream, token.ID, breaker)
 	if err != nil {
-		c.JSON(http.StatusBadGateway, gin.H{"error": "代理請求失敗", "details": err.Error()})
+		if isBodyTooLarge(err) {
+			respondBodyTooLarge(c, service.BodyLimits.MaxRequestBytes)
+			return
+		}
+		if errors.Is(err, errNoUpstream) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "服務URL配置錯誤"})
+			return
+		}
+		if errors.Is(err, errCredentialsUnavailable) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "無法載入服務的後端憑證"})
+			return
+		}
+		respondUpstreamError(c, err)
 		return
 	}
+	defer release()
 	defer proxyResp.Body.Close()
 
-	// 根據回應特性（如 chunked、Content-Length 未指定、SSE 等），決定是否以串流轉發。
-	// 若為串流（或沒有明確 Content-Length），會使用 io.Copy 逐塊轉發以支援長連線/串流；
-	// 否則會先完整讀取後端回應（以計算並設置 Content-Length），再回傳給客戶端。
+	// 可快取的回應讀入後儲存；後端以 304 確認快取內容未變更時，改以快取內容回應
+	proxyResp, err = cache.finish(c, proxyResp)
+	if err != nil {
+		respondReadError(c, err)
+		return
+	}
+	writeProxyResponse(c, service, proxyResp)
+}
+
+// respondUpstreamError 回應無法連線或逾時的後端請求，並在伺服器端記錄遮蔽後端 URL 查詢參數的錯誤
+func respondUpstreamError(c *gin.Context, err error) {
+	fmt.Printf("代理請求 %s 失敗: %v\n", c.GetString("logEndpoint"), redactUpstreamError(err))
+	if isTimeout(err) {
+		c.JSON(http.StatusGatewayTimeout, gin.H{"error": "代理請求逾時", "details": upstreamErrorDetails(err)})
+		return
+	}
+	c.JSON(http.StatusBadGateway, gin.H{"error": "代理請求失敗", "details": upstreamErrorDetails(err)})
+}
 
-	// 將響應標頭複製至回應，但會針對 Location 與 Set-Cookie 做必要的調整
-	for key, values := range proxyResp.Header {
-		// 不修改 Location
-		if strings.EqualFold(key, "Location") {
-			for _, value := range values {
-				c.Writer.Header().Add(key, value)
-			}
-			continue
-		}
+// upstreamErrorDetails 回傳可以回應給用戶端的錯誤說明。http.Client 的錯誤（*url.Error）包含完整的後端 URL，
+// 可能含有以查詢參數注入的後端憑證，因此只回傳底層的錯誤
+func upstreamErrorDetails(err error) string {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err.Error()
+	}
+	return err.Error()
+}
 
+// redactUpstreamError 移除錯誤中後端 URL 的查詢參數與使用者資訊，供伺服器端記錄
+func redactUpstreamError(err error) error {
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) {
+		return err
+	}
+	u, parseErr := url.Parse(urlErr.URL)
+	if parseErr != nil {
+		return urlErr.Err
+	}
+	u.User = nil
+	if u.RawQuery != "" {
+		u.RawQuery = "redacted"
+	}
+	return &url.Error{Op: urlErr.Op, URL: u.String(), Err: urlErr.Err}
+}
+
+// writeProxyResponse 將後端（或快取）的回應寫回用戶端。轉發端對端標頭並移除 Set-Cookie 的 Domain 屬性，
+// 加上禁止搜尋引擎索引與禁止快取的標頭（啟用回應快取的服務除外）；3xx 回應原樣回傳，不跟隨重新導向。
+// 串流回應（見 shouldStream）與超過緩衝上限的回應逐塊轉發，其餘完整讀入後以實際長度回傳。
+func writeProxyResponse(c *gin.Context, service models.Service, proxyResp *http.Response) {
+	// 將響應標頭複製至回應（逐跳標頭除外），但會針對 Location 與 Set-Cookie 做必要的調整
+	removeHopHeaders(proxyResp.Header)
+	if service.WebApp {
+		// 網頁應用模式：Location、Content-Location、Refresh 與 cookie Path 改寫到代理路徑前綴
+		newLocationRewriter(c, service, proxyResp).rewriteHeaders(proxyResp.Header)
+	}
+	for key, values := range proxyResp.Header {
 		// 處理 Set-Cookie，若包含 Domain 指定，則移除 Domain，改為代理用戶端網域
 		if strings.EqualFold(key, "Set-Cookie") {
 			for _, value := range values {
-				cookieValue := regexp.MustCompile(`(?i)Domain=[^;]+;?\s?`).ReplaceAllString(value, "")
-				c.Writer.Header().Add(key, cookieValue)
+				c.Writer.Header().Add(key, removeCookieDomain(value))
 			}
 			continue
 		}
@@ -101,32 +191,45 @@ func ProxyRequest(c *gin.Context) {
 		}
 	}
 
-	// 添加禁止快取與 SEO 優先的標頭
-	c.Header("Cache-Control", "no-store, no-cache, must-revalidate, max-age=0")
-	c.Header("Pragma", "no-cache")
-	c.Header("Expires", "0")
+	// 添加禁止快取與 SEO 優先的標頭；啟用回應快取的服務保留後端的快取標頭，並以 X-Cache 標示快取結果
+	if service.Cache.Enabled {
+		c.Header("X-Cache", c.GetString("cacheStatus"))
+	} else {
+		c.Header("Cache-Control", "no-store, no-cache, must-revalidate, max-age=0")
+		c.Header("Pragma", "no-cache")
+		c.Header("Expires", "0")
+	}
 	// 防止搜尋引擎索引經由代理的內容
 	c.Header("X-Robots-Tag", "noindex, nofollow")
 
-	// 根據多種條件決定是否要串流 (Transfer-Encoding, Content-Length, Content-Type)
-	if shouldStream(proxyResp) {
+	// 啟用 body 改寫時，讀入 HTML（與 CSS）回應後改寫；串流回應與超過緩衝上限的回應不改寫
+	if writeRewrittenBody(c, service, proxyResp) {
+		return
+	}
+
+	// 根據多種條件決定是否要串流 (Transfer-Encoding, Content-Length, Content-Type)，
+	// 長度超過緩衝上限的回應同樣串流，Content-Length 沿用後端的值
+	if shouldStream(proxyResp) || proxyResp.ContentLength > responseBufferLimit(service) {
 		c.Status(proxyResp.StatusCode)
 		// HEAD 請求不應該寫 body
 		if c.Request.Method != http.MethodHead {
-			if _, copyErr := io.Copy(c.Writer, proxyResp.Body); copyErr != nil {
-				c.Error(copyErr)
+			if err := streamResponse(c, service, proxyResp); err != nil {
+				c.Error(err)
 			}
 		}
+		copyTrailers(c, proxyResp)
 		return
 	}
 
 	// 非串流 - 先讀入（以便可能需要修改或計算長度），但不修改內容以避免覆寫
 	respBody, err := io.ReadAll(proxyResp.Body)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "讀取代理響應失敗"})
+		respondReadError(c, err)
 		return
 	}
 
+	copyTrailers(c, proxyResp)
+
 	// 更新 Content-Length 為實際長度，並移除 Transfer-Encoding 以避免與 chunked 衝突
 	c.Writer.Header().Set("Content-Length", strconv.Itoa(len(respBody)))
 	c.Writer.Header().Del("Transfer-Encoding")
@@ -138,11 +241,74 @@ func ProxyRequest(c *gin.Context) {
 	}
 }
 
-// shouldStream 判斷回應是否需要串流轉發。條件包括：
+// newProxyRequest 建立轉發至後端目標的請求（不含 body），endpoint 為套用路由規則後的路徑。會複製原始請求的查詢參數與端對端標頭，
+// 移除逐跳標頭與用戶端自帶的 X-Infra-* 標頭，並依服務設定加入轉送標頭、身分資訊與後端憑證。
+func newProxyRequest(ctx context.Context, c *gin.Context, service models.Service, upstream *upstreamTarget, endpoint string) (*http.Request, error) {
+	proxyReq, err := newUpstreamRequest(ctx, c, upstream, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	setForwardingHeaders(c, proxyReq, service)
+	if err := setIdentityHeaders(c, proxyReq, service); err != nil {
+		return nil, err
+	}
+	if err := setCredentials(proxyReq, service.ID); err != nil {
+		return nil, err
+	}
+	return proxyReq, nil
+}
+
+// newUpstreamRequest 建立轉發至後端目標的請求（不含 body），複製原始請求的查詢參數與標頭並移除逐跳標頭，
+// 不加入轉送標頭、身分資訊與後端憑證
+func newUpstreamRequest(ctx context.Context, c *gin.Context, upstream *upstreamTarget, endpoint string) (*http.Request, error) {
+	r := c.Request
+
+	// 構建目標URL
+	targetURL := *upstream.url
+
+	// 拼接完整的目標路徑（保留結尾斜線與連續斜線）
+	targetURL.Path = joinUpstreamPath(targetURL.Path, endpoint)
+	targetURL.RawPath = ""
+
+	// 複製URL查詢參數
+	targetURL.RawQuery = r.URL.RawQuery
+
+	// 創建新的請求
+	proxyReq, err := http.NewRequestWithContext(ctx, r.Method, targetURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// unix socket 目標的 URL 主機名稱僅用於連線，Host 標頭改送 localhost
+	if isUnixSocketURL(&targetURL) {
+		proxyReq.Host = unixSocketRequestHost
+	}
+
+	// 複製標頭
+	for key, values := range r.Header {
+		// 略過Host標頭，因為它會被http.Client設定
+		if key != "Host" {
+			for _, value := range values {
+				proxyReq.Header.Add(key, value)
+			}
+		}
+	}
+	removeHopHeaders(proxyReq.Header)
+	if acceptsTrailers(r.Header) {
+		proxyReq.Header.Set("Te", "trailers")
+	}
+	// 用戶端宣告的請求 trailer 在讀完 body 後才會填入，由 Transport 在送出 body 後轉送
+	if len(r.Trailer) > 0 {
+		proxyReq.Trailer = r.Trailer
+	}
+	return proxyReq, nil
+}
+
+// shouldStream 依回應的傳輸方式判斷是否需要串流轉發。條件包括：
 // - Transfer-Encoding 包含 chunked（HTTP/1.1 chunked 傳輸）
-// - Content-Length 不可得（== -1，常見於 HTTP/2 或沒有指定長度的回應）
+// - Content-Length 不可得（== -1，常見於 HTTP/2、gRPC 或沒有指定長度的回應）
 // - Content-Type 為 text/event-stream 或 multipart/x-mixed-replace（SSE 或 multipart streaming）
-// 注意：我們不再基於回應大小（閾值）來判斷是否串流；判斷以實際的傳輸意圖為主。
+// 此處不考慮回應大小；長度超過緩衝上限（responseBufferLimit）的回應由呼叫端另外判斷後串流。
 func shouldStream(resp *http.Response) bool {
 	if resp == nil {
 		return false
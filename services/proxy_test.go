@@ -0,0 +1,80 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"infra-manager/db"
+	"infra-manager/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testServiceIDs 讓每個測試使用不同的服務 ID，避免共用連線池、熔斷器等以服務 ID 快取的狀態
+var testServiceIDs atomic.Uint32
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+
+	// 後端憑證與鏡像紀錄使用記憶體中的資料庫
+	database, err := gorm.Open(sqlite.Open("file:services_test?mode=memory&cache=shared"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		log.Fatalf("無法開啟測試資料庫: %v", err)
+	}
+	if err := database.AutoMigrate(&models.ServiceSecret{}, &models.MirrorLog{}); err != nil {
+		log.Fatalf("測試資料庫遷移失敗: %v", err)
+	}
+	db.DB = database
+
+	os.Exit(m.Run())
+}
+
+// newTestService 建立轉發到 baseURL 的服務設定
+func newTestService(baseURL string) models.Service {
+	id := uint(testServiceIDs.Add(1))
+	return models.Service{ID: id, Name: fmt.Sprintf("test-%d", id), BaseURL: baseURL, IsActive: true}
+}
+
+// newTestProxy 建立只包含 ProxyRequest 的代理，以 /use/test/<endpoint> 轉發到服務，
+// 上下文中的服務、Token 與使用者由此設定，取代 TokenAuth
+func newTestProxy(t testing.TB, service models.Service) *httptest.Server {
+	engine := gin.New()
+	engine.Any("/use/test/*endpoint", func(c *gin.Context) {
+		c.Set("service", service)
+		c.Set("token", models.Token{ID: 1, UserID: 1, ServiceID: service.ID})
+		c.Set("user", models.User{ID: 1, Username: "tester"})
+		c.Set("targetEndpoint", strings.TrimPrefix(c.Param("endpoint"), "/"))
+		c.Set("proxyPrefix", "/use/test")
+		c.Set("logEndpoint", c.Request.URL.Path)
+	}, ProxyRequest)
+
+	proxy := httptest.NewServer(engine)
+	t.Cleanup(func() {
+		proxy.Close()
+		InvalidateService(service.ID)
+	})
+	return proxy
+}
+
+// countConnections 計算後端伺服器接受的連線數
+func countConnections(server *httptest.Server) *atomic.Int64 {
+	var count atomic.Int64
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			count.Add(1)
+		}
+	}
+	return &count
+}
@@ -0,0 +1,173 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+
+	"infra-manager/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errNoUpstream = errors.New("服務沒有可用的後端目標")
+
+// requestBody 是轉發給後端的請求 body。已完整緩衝的 body 可在重試時重送，否則只能串流一次。
+type requestBody struct {
+	buf    []byte
+	stream io.ReadCloser
+	length int64
+}
+
+func (b *requestBody) replayable() bool {
+	return b.stream == nil
+}
+
+// apply 將 body 設定到代理請求
+func (b *requestBody) apply(req *http.Request) {
+	req.ContentLength = b.length
+	if b.stream != nil {
+		req.Body = b.stream
+		return
+	}
+	if len(b.buf) > 0 {
+		req.Body = io.NopCloser(bytes.NewReader(b.buf))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(b.buf)), nil
+		}
+	}
+}
+
+// prepareRequestBody 決定請求 body 的轉發方式。只有可能重試的請求才會緩衝 body，
+// 且不超過 RetryPolicy.BodyLimit；其餘情況直接串流，避免將大型請求讀入記憶體。
+func prepareRequestBody(r *http.Request, policy models.RetryPolicy) (*requestBody, error) {
+	if r.Body == nil || r.Body == http.NoBody || r.ContentLength == 0 {
+		return &requestBody{}, nil
+	}
+
+	limit := policy.BodyLimit()
+	if policy.MaxRetries <= 0 || !isIdempotent(r.Method) || r.ContentLength > limit {
+		return &requestBody{stream: r.Body, length: r.ContentLength}, nil
+	}
+
+	buf, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(buf)) > limit {
+		// 未指定長度且超過上限，將已讀取的部分與剩餘內容串接後直接串流
+		return &requestBody{stream: io.NopCloser(io.MultiReader(bytes.NewReader(buf), r.Body)), length: r.ContentLength}, nil
+	}
+	return &requestBody{buf: buf, length: int64(len(buf))}, nil
+}
+
+// doWithRetries 將請求送往 upstream，依服務的重試設定在失敗時重新選擇後端目標並重試，每次嘗試的結果都會回報給熔斷器。
+// 成功時回傳的 release 需在讀完回應後呼叫，以更新目標的進行中請求數。
+// 最後一次嘗試（或熔斷器不再放行時的最後一次）仍回應 502、503、504 時，照常回傳該回應交由呼叫端轉發。
+func doWithRetries(ctx context.Context, c *gin.Context, client *http.Client, service models.Service, route route, upstream *upstreamTarget, tokenID uint, breaker *circuitBreaker) (*http.Response, func(), error) {
+	body, err := prepareRequestBody(c.Request, service.Retry)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	attempts := 1
+	if body.replayable() && isIdempotent(c.Request.Method) {
+		attempts += max(service.Retry.MaxRetries, 0)
+	}
+
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 {
+			if upstream, err = route.pickUpstream(service, tokenID); err != nil {
+				return nil, nil, errNoUpstream
+			}
+		}
+		proxyReq, err := newProxyRequest(ctx, c, service, upstream, route.path)
+		if err != nil {
+			return nil, nil, err
+		}
+		body.apply(proxyReq)
+
+		upstream.active.Add(1)
+		resp, err := client.Do(proxyReq)
+		failed := err != nil || isFailureStatus(resp.StatusCode)
+
+		// 用戶端自行中斷或請求 body 超過上限不代表後端故障，不計入熔斷
+		if err == nil || c.Request.Context().Err() == nil && !isBodyTooLarge(err) {
+			breaker.record(!failed, time.Now())
+		}
+
+		// 熔斷器因失敗而開啟，或試探期間的請求數已用完時，不再重試，回傳這次的結果
+		if !failed || attempt >= attempts || ctx.Err() != nil || !allowRetry(breaker) {
+			if err != nil {
+				upstream.active.Add(-1)
+				return nil, nil, err
+			}
+			return resp, func() { upstream.active.Add(-1) }, nil
+		}
+
+		if resp != nil {
+			// 讀取少量剩餘內容後關閉，讓連線可以重複使用
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+			resp.Body.Close()
+		}
+		upstream.active.Add(-1)
+
+		if !sleepContext(ctx, withJitter(service.Retry.Backoff(attempt))) {
+			if err == nil {
+				err = ctx.Err()
+			}
+			return nil, nil, err
+		}
+	}
+}
+
+// allowRetry 判斷熔斷器是否允許再次嘗試，每次重試與新的請求相同，都需經熔斷器放行
+func allowRetry(breaker *circuitBreaker) bool {
+	ok, _ := breaker.allow(time.Now())
+	return ok
+}
+
+// isIdempotent 判斷方法是否為冪等，只有冪等方法可以安全地重試
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// isFailureStatus 判斷後端回應是否代表後端暫時無法處理（可重試，並計入熔斷）
+func isFailureStatus(status int) bool {
+	return status == http.StatusBadGateway || status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+}
+
+// isTimeout 判斷錯誤是否為逾時
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// withJitter 為等待時間加上最多 50% 的隨機延長，避免多個請求同時重試
+func withJitter(d time.Duration) time.Duration {
+	return d + time.Duration(rand.Int63n(int64(d)/2+1))
+}
+
+// sleepContext 等待指定時間，ctx 結束時提早返回 false
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
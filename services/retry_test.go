@@ -0,0 +1,37 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"infra-manager/models"
+)
+
+// 重試途中熔斷器開啟時不再重試，直接回傳最後一次的回應
+func TestRetriesStopWhenCircuitOpens(t *testing.T) {
+	var attempts atomic.Int64
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer backend.Close()
+
+	service := newTestService(backend.URL)
+	service.Retry = models.RetryPolicy{MaxRetries: 5, BackoffMs: 1}
+	service.CircuitBreaker = models.CircuitBreaker{FailureThreshold: 2, OpenSeconds: 60}
+	proxy := newTestProxy(t, service)
+
+	resp, err := http.Get(proxy.URL + "/use/test/items")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("應回傳最後一次嘗試的 502，得到 %d", resp.StatusCode)
+	}
+	if n := attempts.Load(); n != 2 {
+		t.Errorf("熔斷器在第 2 次失敗後開啟，後端應只收到 2 個請求，得到 %d", n)
+	}
+}
@@ -0,0 +1,185 @@
+package services
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"infra-manager/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	cookieDomainPattern = regexp.MustCompile(`(?i)Domain=[^;]+;?\s?`)
+	cookiePathPattern   = regexp.MustCompile(`(?i)(;\s*Path=)([^;]*)`)
+	refreshURLPattern   = regexp.MustCompile(`(?i)^(\s*[0-9.]+\s*[;,]\s*url\s*=\s*)(['"]?)([^'"]*)(['"]?\s*)$`)
+)
+
+// locationRewriter 在網頁應用模式下，將後端回應中指向後端的連結改寫到代理路徑前綴，
+// 讓瀏覽器的重新導向與 cookie 留在 /use/<service>/<token>/ 之下
+type locationRewriter struct {
+	prefix     string     // 代理路徑前綴，例如 /use/<service>/<token>
+	basePath   string     // 回應來源後端目標的路徑
+	targets    []*url.URL // 服務的所有後端目標（含路由規則指定的後端），用於辨識指向後端的絕對 URL
+	publicHost string     // 用戶端請求的主機，後端依 X-Forwarded-Host 產生的絕對 URL 也需改寫
+}
+
+func newLocationRewriter(c *gin.Context, service models.Service, resp *http.Response) *locationRewriter {
+	r := &locationRewriter{
+		prefix:     strings.TrimSuffix(c.GetString("proxyPrefix"), "/"),
+		publicHost: c.Request.Host,
+	}
+	raws := make([]string, 0, len(service.Targets())+len(service.Routes))
+	for _, target := range service.Targets() {
+		raws = append(raws, target.URL)
+	}
+	for _, rule := range service.Routes {
+		if rule.Upstream != "" {
+			raws = append(raws, rule.Upstream)
+		}
+	}
+	for _, raw := range raws {
+		parsed, err := parseUpstreamURL(raw)
+		if err != nil {
+			continue
+		}
+		r.targets = append(r.targets, parsed)
+		if resp.Request != nil && sameOrigin(parsed, resp.Request.URL) {
+			r.basePath = parsed.Path
+		}
+		// unix socket 目標收到的 Host 為 localhost，後端產生的絕對 URL 會指向此主機
+		if isUnixSocketURL(parsed) {
+			r.targets = append(r.targets, &url.URL{Scheme: "http", Host: unixSocketRequestHost, Path: parsed.Path})
+		}
+	}
+	return r
+}
+
+// rewriteHeaders 改寫 Location、Content-Location、Refresh 與 Set-Cookie 的 Path
+func (r *locationRewriter) rewriteHeaders(header http.Header) {
+	for _, name := range []string{"Location", "Content-Location"} {
+		if value := header.Get(name); value != "" {
+			header.Set(name, r.rewriteURL(value))
+		}
+	}
+	if value := header.Get("Refresh"); value != "" {
+		header.Set("Refresh", r.rewriteRefresh(value))
+	}
+	cookies := header.Values("Set-Cookie")
+	for i, cookie := range cookies {
+		cookies[i] = r.rewriteCookiePath(cookie)
+	}
+}
+
+// rewriteURL 改寫指向後端的絕對 URL 與以 / 開頭的路徑；相對路徑由瀏覽器依目前網址解析，不需改寫，
+// 指向其他網站的 URL 保持不變
+func (r *locationRewriter) rewriteURL(value string) string {
+	u, err := url.Parse(value)
+	if err != nil {
+		return value
+	}
+
+	basePath := r.basePath
+	if u.Host != "" {
+		target := r.matchTarget(u)
+		switch {
+		case target != nil:
+			basePath = target.Path
+		case strings.EqualFold(u.Host, r.publicHost):
+			// 後端依 X-Forwarded-Host 產生的網址，路徑仍是後端的路徑
+		default:
+			return value
+		}
+	} else if !strings.HasPrefix(u.Path, "/") {
+		return value
+	}
+
+	// 以編碼後的路徑處理，避免改變後端原本的編碼方式
+	rewritten := r.mapPath(u.EscapedPath(), basePath)
+	if u.RawQuery != "" || u.ForceQuery {
+		rewritten += "?" + u.RawQuery
+	}
+	if u.Fragment != "" {
+		rewritten += "#" + u.EscapedFragment()
+	}
+	return rewritten
+}
+
+// rewriteRefresh 改寫 Refresh 標頭（例如 "5; url=/login"）中的網址
+func (r *locationRewriter) rewriteRefresh(value string) string {
+	match := refreshURLPattern.FindStringSubmatch(value)
+	if match == nil {
+		return value
+	}
+	return match[1] + match[2] + r.rewriteURL(match[3]) + match[4]
+}
+
+// rewriteCookiePath 改寫 cookie 的 Path 屬性。未指定 Path 時瀏覽器會以目前網址的目錄為預設值，已在前綴之下。
+func (r *locationRewriter) rewriteCookiePath(cookie string) string {
+	return cookiePathPattern.ReplaceAllStringFunc(cookie, func(attr string) string {
+		match := cookiePathPattern.FindStringSubmatch(attr)
+		cookiePath := strings.TrimSpace(match[2])
+		if !strings.HasPrefix(cookiePath, "/") {
+			return attr
+		}
+		return match[1] + r.mapPath(cookiePath, r.basePath)
+	})
+}
+
+// mapPath 將後端路徑對應到代理路徑：去除後端目標的路徑後接在代理前綴之後。已在前綴之下的路徑
+// （後端依 X-Forwarded-Prefix 產生）保持不變。
+func (r *locationRewriter) mapPath(p, basePath string) string {
+	if p == r.prefix || strings.HasPrefix(p, r.prefix+"/") {
+		return p
+	}
+	if basePath = strings.TrimSuffix(basePath, "/"); basePath != "" {
+		if p == basePath {
+			p = "/"
+		} else if strings.HasPrefix(p, basePath+"/") {
+			p = strings.TrimPrefix(p, basePath)
+		}
+	}
+	return r.prefix + p
+}
+
+func (r *locationRewriter) matchTarget(u *url.URL) *url.URL {
+	for _, target := range r.targets {
+		if sameOrigin(target, u) {
+			return target
+		}
+	}
+	return nil
+}
+
+// sameOrigin 比對協定、主機與埠號；未指定協定（//host/path）時沿用另一方的協定
+func sameOrigin(a, b *url.URL) bool {
+	if a.Scheme != "" && b.Scheme != "" && !strings.EqualFold(a.Scheme, b.Scheme) {
+		return false
+	}
+	scheme := a.Scheme
+	if scheme == "" {
+		scheme = b.Scheme
+	}
+	return strings.EqualFold(hostWithPort(a, scheme), hostWithPort(b, scheme))
+}
+
+// hostWithPort 回傳主機與埠號，未指定埠號時補上協定的預設埠號
+func hostWithPort(u *url.URL, scheme string) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	switch strings.ToLower(scheme) {
+	case "http":
+		return u.Host + ":80"
+	case "https":
+		return u.Host + ":443"
+	}
+	return u.Host
+}
+
+// removeCookieDomain 移除 Set-Cookie 的 Domain 屬性，讓 cookie 設定在代理的網域
+func removeCookieDomain(cookie string) string {
+	return cookieDomainPattern.ReplaceAllString(cookie, "")
+}
@@ -0,0 +1,192 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"infra-manager/models"
+	"infra-manager/urlpath"
+
+	"github.com/gin-gonic/gin"
+)
+
+// route 是路由規則套用後的結果
+type route struct {
+	path     string // 轉發給後端的路徑（相對於後端目標的路徑），空字串表示後端目標本身
+	upstream string // 規則指定的後端 URL，空字串表示使用服務的後端目標
+}
+
+var (
+	// routeRegexps 快取已編譯的路由正規表示式
+	routeRegexps sync.Map // pattern -> *regexp.Regexp
+	// routeTargets 保存路由規則指定的後端目標，以記錄進行中請求數（不做健康檢查）；服務設定變更時由 InvalidateService 清除
+	routeTargets sync.Map // routeTargetKey -> *upstreamTarget
+)
+
+// routeTargetKey 是路由規則指定的後端目標在 routeTargets 中的鍵
+type routeTargetKey struct {
+	serviceID uint
+	url       string
+}
+
+// ValidateRoutes 檢查路由規則的比對方式、正規表示式與後端 URL
+func ValidateRoutes(rules []models.RouteRule) error {
+	for i, rule := range rules {
+		switch rule.Match {
+		case models.RouteMatchPrefix:
+			if !strings.HasPrefix(rule.Pattern, "/") {
+				return fmt.Errorf("第 %d 條路由規則的前綴必須以 / 開頭", i+1)
+			}
+			if rule.Rewrite != "" && !strings.HasPrefix(rule.Rewrite, "/") {
+				return fmt.Errorf("第 %d 條路由規則的改寫路徑必須以 / 開頭", i+1)
+			}
+		case models.RouteMatchRegex:
+			if _, err := regexp.Compile(rule.Pattern); err != nil {
+				return fmt.Errorf("第 %d 條路由規則的正規表示式無效: %v", i+1, err)
+			}
+		default:
+			return fmt.Errorf("第 %d 條路由規則的比對方式無效，可用值：prefix、regex", i+1)
+		}
+		if rule.Upstream != "" {
+			if _, err := parseUpstreamURL(rule.Upstream); err != nil {
+				return fmt.Errorf("第 %d 條路由規則: %v", i+1, err)
+			}
+		}
+	}
+	return nil
+}
+
+// requestRoutePath 回傳用戶端請求的服務內路徑（以 / 開頭），路由規則以此比對。
+// targetEndpoint 已由 TokenAuth 解析 . 與 ..，與Token存取範圍檢查使用的路徑相同。
+// 請求為服務根路徑且沒有結尾斜線（/use/<service>/<token>）時回傳空字串，以保留與後端目標本身的差異。
+func requestRoutePath(c *gin.Context) string {
+	endpoint := c.GetString("targetEndpoint")
+	if endpoint == "" && !strings.HasSuffix(c.Request.URL.Path, "/") {
+		return ""
+	}
+	return "/" + endpoint
+}
+
+// matchRoute 依序比對路由規則，套用第一條符合的規則；沒有符合的規則時原樣轉發。
+// p 為服務內的路徑（以 / 開頭，或空字串表示服務根路徑）。
+func matchRoute(rules []models.RouteRule, p string) route {
+	matchPath := p
+	if matchPath == "" {
+		matchPath = "/"
+	}
+
+	for _, rule := range rules {
+		rewritten, ok := applyRouteRule(rule, matchPath)
+		if !ok {
+			continue
+		}
+		if rewritten == matchPath {
+			rewritten = p
+		}
+		return route{path: rewritten, upstream: rule.Upstream}
+	}
+	return route{path: p}
+}
+
+// applyRouteRule 比對單一規則，符合時回傳改寫後的路徑
+func applyRouteRule(rule models.RouteRule, p string) (string, bool) {
+	switch rule.Match {
+	case models.RouteMatchPrefix:
+		prefix := strings.TrimSuffix(rule.Pattern, "/**")
+		var rest string
+		switch {
+		case strings.HasSuffix(prefix, "/"):
+			// 以 / 結尾的前綴直接比對字串開頭
+			if !strings.HasPrefix(p, prefix) {
+				return "", false
+			}
+			rest = "/" + strings.TrimPrefix(p, prefix)
+		case p == prefix || strings.HasPrefix(p, prefix+"/"):
+			// 以路徑段為單位比對，/v2 不會符合 /v20
+			rest = strings.TrimPrefix(p, prefix)
+		default:
+			return "", false
+		}
+		if rule.Rewrite == "" {
+			return p, true
+		}
+		// 改寫為 / 且請求恰為前綴本身時結果為空字串，即後端目標本身（不加上結尾斜線）
+		return strings.TrimSuffix(rule.Rewrite, "/") + rest, true
+
+	case models.RouteMatchRegex:
+		re, err := routeRegexp(rule.Pattern)
+		if err != nil {
+			return "", false
+		}
+		match := re.FindStringSubmatchIndex(p)
+		if match == nil {
+			return "", false
+		}
+		if rule.Rewrite == "" {
+			return p, true
+		}
+		expanded := re.ExpandString(nil, rule.Rewrite, p, match)
+		rewritten := p[:match[0]] + string(expanded) + p[match[1]:]
+		if !strings.HasPrefix(rewritten, "/") {
+			rewritten = "/" + rewritten
+		}
+		return rewritten, true
+	}
+	return "", false
+}
+
+func routeRegexp(pattern string) (*regexp.Regexp, error) {
+	if cached, ok := routeRegexps.Load(pattern); ok {
+		return cached.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	routeRegexps.Store(pattern, re)
+	return re, nil
+}
+
+// pickUpstream 選擇後端目標：規則指定後端 URL 時使用該 URL，否則依服務的負載平衡方式選擇
+func (r route) pickUpstream(service models.Service, tokenID uint) (*upstreamTarget, error) {
+	if r.upstream == "" {
+		return pickUpstream(service, tokenID)
+	}
+	key := routeTargetKey{serviceID: service.ID, url: r.upstream}
+	if cached, ok := routeTargets.Load(key); ok {
+		return cached.(*upstreamTarget), nil
+	}
+	u, err := parseUpstreamURL(r.upstream)
+	if err != nil {
+		return nil, err
+	}
+	target := &upstreamTarget{raw: r.upstream, url: u, weight: 1}
+	target.healthy.Store(true)
+	actual, _ := routeTargets.LoadOrStore(key, target)
+	return actual.(*upstreamTarget), nil
+}
+
+// removeRouteTargets 清除服務的路由規則指定的後端目標
+func removeRouteTargets(serviceID uint) {
+	routeTargets.Range(func(key, _ any) bool {
+		if key.(routeTargetKey).serviceID == serviceID {
+			routeTargets.Delete(key)
+		}
+		return true
+	})
+}
+
+// joinUpstreamPath 將路徑接在後端目標的路徑之後。與 path.Join 不同，會保留結尾斜線與連續的斜線，
+// 且 .. 不會超出後端目標的路徑。
+func joinUpstreamPath(base, p string) string {
+	if p == "" {
+		if base == "" {
+			return "/"
+		}
+		return base
+	}
+	// 正規表示式改寫的結果可能含有 . 或 ..，需再解析一次
+	return strings.TrimSuffix(base, "/") + urlpath.CleanDotSegments(p)
+}
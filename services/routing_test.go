@@ -0,0 +1,107 @@
+package services
+
+import (
+	"testing"
+
+	"infra-manager/models"
+)
+
+func TestApplyRouteRule(t *testing.T) {
+	tests := []struct {
+		name string
+		rule models.RouteRule
+		path string
+		want string
+		ok   bool
+	}{
+		{"前綴相符不改寫", models.RouteRule{Match: models.RouteMatchPrefix, Pattern: "/v2"}, "/v2/users", "/v2/users", true},
+		{"前綴以路徑段比對", models.RouteRule{Match: models.RouteMatchPrefix, Pattern: "/v2"}, "/v20/users", "", false},
+		{"前綴恰為請求路徑", models.RouteRule{Match: models.RouteMatchPrefix, Pattern: "/v2", Rewrite: "/api"}, "/v2", "/api", true},
+		{"前綴改寫保留其餘路徑", models.RouteRule{Match: models.RouteMatchPrefix, Pattern: "/v2/**", Rewrite: "/api/v2"}, "/v2/users/", "/api/v2/users/", true},
+		{"以斜線結尾的前綴比對字串開頭", models.RouteRule{Match: models.RouteMatchPrefix, Pattern: "/static/", Rewrite: "/assets"}, "/static/app.js", "/assets/app.js", true},
+		{"改寫為根路徑", models.RouteRule{Match: models.RouteMatchPrefix, Pattern: "/v2", Rewrite: "/"}, "/v2", "", true},
+		{"正規表示式群組", models.RouteRule{Match: models.RouteMatchRegex, Pattern: `^/users/(\d+)$`, Rewrite: "/members/$1"}, "/users/42", "/members/42", true},
+		{"正規表示式取代部分路徑", models.RouteRule{Match: models.RouteMatchRegex, Pattern: `/old/`, Rewrite: "/new/"}, "/a/old/b", "/a/new/b", true},
+		{"正規表示式改寫補上開頭斜線", models.RouteRule{Match: models.RouteMatchRegex, Pattern: `^/x/(.*)$`, Rewrite: "$1"}, "/x/y", "/y", true},
+		{"正規表示式不符合", models.RouteRule{Match: models.RouteMatchRegex, Pattern: `^/users/\d+$`}, "/users/me", "", false},
+		{"無效的比對方式", models.RouteRule{Match: "glob", Pattern: "/v2"}, "/v2", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := applyRouteRule(tt.rule, tt.path)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("applyRouteRule(%q) = %q, %v，預期 %q, %v", tt.path, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestMatchRoute(t *testing.T) {
+	rules := []models.RouteRule{
+		{Match: models.RouteMatchPrefix, Pattern: "/v1/admin", Upstream: "http://admin.internal"},
+		{Match: models.RouteMatchPrefix, Pattern: "/v1", Rewrite: "/api"},
+		{Match: models.RouteMatchPrefix, Pattern: "/", Upstream: "http://fallback.internal"},
+	}
+	tests := []struct {
+		path string
+		want route
+	}{
+		// 第一條符合的規則生效
+		{"/v1/admin/users", route{path: "/v1/admin/users", upstream: "http://admin.internal"}},
+		{"/v1/users", route{path: "/api/users"}},
+		{"/other", route{path: "/other", upstream: "http://fallback.internal"}},
+		// 服務根路徑以 / 比對，但未改寫時保留空字串（後端目標本身）
+		{"", route{path: "", upstream: "http://fallback.internal"}},
+	}
+	for _, tt := range tests {
+		if got := matchRoute(rules, tt.path); got != tt.want {
+			t.Errorf("matchRoute(%q) = %+v，預期 %+v", tt.path, got, tt.want)
+		}
+	}
+
+	if got := matchRoute(nil, "/v1/users"); got != (route{path: "/v1/users"}) {
+		t.Errorf("沒有路由規則時應原樣轉發，得到 %+v", got)
+	}
+}
+
+func TestJoinUpstreamPath(t *testing.T) {
+	tests := []struct {
+		base, path, want string
+	}{
+		{"", "", "/"},
+		{"/api", "", "/api"},
+		{"/api/", "/users", "/api/users"},
+		{"/api", "/users/", "/api/users/"},
+		{"/api", "/a//b", "/api/a//b"},
+		{"/api", "/a/./b/..", "/api/a/"},
+		{"/api", "/../../etc/passwd", "/api/etc/passwd"},
+		// TokenAuth 正規化後的端點不會再被改變，後端收到的路徑與存取範圍檢查的路徑一致
+		{"/api", "/v1/secret/public", "/api/v1/secret/public"},
+	}
+	for _, tt := range tests {
+		if got := joinUpstreamPath(tt.base, tt.path); got != tt.want {
+			t.Errorf("joinUpstreamPath(%q, %q) = %q，預期 %q", tt.base, tt.path, got, tt.want)
+		}
+	}
+}
+
+// 路由規則指定的後端目標以服務區分，服務設定變更時清除
+func TestRouteTargetsInvalidated(t *testing.T) {
+	service := newTestService("http://primary.internal")
+	r := route{upstream: "http://other.internal"}
+	target, err := r.pickUpstream(service, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := r.pickUpstream(service, 1); again != target {
+		t.Error("同一服務的相同後端 URL 應重複使用同一個目標")
+	}
+	if other, _ := r.pickUpstream(newTestService("http://primary.internal"), 1); other == target {
+		t.Error("不同服務的路由目標不應共用")
+	}
+
+	InvalidateService(service.ID)
+	if _, ok := routeTargets.Load(routeTargetKey{serviceID: service.ID, url: r.upstream}); ok {
+		t.Error("InvalidateService 後應清除服務的路由目標")
+	}
+}
@@ -0,0 +1,212 @@
+package services
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"sync"
+	"time"
+
+	"infra-manager/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// streamWriter 將串流回應寫給用戶端，依設定在每次寫入後或定期 flush。
+// 讀取後端與送出 SSE keep-alive 在不同 goroutine 進行，寫入以 mu 保護。
+type streamWriter struct {
+	mu        sync.Mutex
+	w         gin.ResponseWriter
+	interval  time.Duration // 0 表示每次寫入後立即 flush
+	pending   bool          // 有尚未 flush 的資料
+	lastWrite time.Time
+	sse       *sseCounter // SSE 回應的事件計數，其他回應為 nil
+}
+
+func (s *streamWriter) write(p []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.sse != nil {
+		s.sse.feed(p)
+	}
+	if _, err := s.w.Write(p); err != nil {
+		return err
+	}
+	s.lastWrite = time.Now()
+	if s.interval > 0 {
+		s.pending = true
+		return nil
+	}
+	s.w.Flush()
+	return nil
+}
+
+// flushPending flush 累積的資料（定期 flush 時使用）
+func (s *streamWriter) flushPending() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pending {
+		s.w.Flush()
+		s.pending = false
+	}
+}
+
+// keepAlive 在 SSE 串流閒置超過 idle 時送出註解行。只在行首送出，避免插入未完成的欄位中；
+// 位於事件之間時加上空行，否則只送出註解行，不會提早結束事件。
+func (s *streamWriter) keepAlive(idle time.Duration, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastWrite) < idle || !s.sse.atLineStart() {
+		return nil
+	}
+	comment := ": keepalive\n"
+	if s.sse.atEventBoundary() {
+		comment += "\n"
+	}
+	if _, err := io.WriteString(s.w, comment); err != nil {
+		return err
+	}
+	s.w.Flush()
+	s.pending = false
+	s.lastWrite = now
+	return nil
+}
+
+// streamResponse 以串流方式轉發回應 body，並記錄串流時間與 SSE 事件數。
+// 回應標頭立即送出；用戶端中斷連線時關閉後端回應，結束後端請求。
+func streamResponse(c *gin.Context, service models.Service, resp *http.Response) error {
+	sw := &streamWriter{w: c.Writer, interval: service.Streaming.FlushInterval(), lastWrite: time.Now()}
+	if isGRPC(resp.Header) {
+		// gRPC 雙向串流的用戶端可能在收到上一則訊息後才送出下一則，一律立即 flush
+		sw.interval = 0
+	}
+	keepAlive := time.Duration(0)
+	if isEventStream(resp.Header) {
+		sw.sse = &sseCounter{}
+		keepAlive = service.Streaming.KeepAlive()
+	}
+
+	start := time.Now()
+	defer func() {
+		c.Set("streamDuration", time.Since(start).Milliseconds())
+		if sw.sse != nil {
+			c.Set("streamEvents", sw.sse.events)
+		}
+	}()
+
+	// 長時間等待資料的串流（SSE、long-poll）先送出回應標頭，讓用戶端與中間的代理知道連線仍有效
+	c.Writer.Flush()
+
+	// 回傳前等待 goroutine 結束，之後不會再寫入 c.Writer（接著會寫入 trailer，handler 返回後也不可再寫入）
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	defer func() {
+		close(done)
+		wg.Wait()
+	}()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		tick := keepAlive
+		if sw.interval > 0 && (tick == 0 || sw.interval < tick) {
+			tick = sw.interval
+		}
+		var ticks <-chan time.Time
+		if tick > 0 {
+			ticker := time.NewTicker(tick)
+			defer ticker.Stop()
+			ticks = ticker.C
+		}
+		for {
+			select {
+			case <-done:
+				return
+			case <-c.Request.Context().Done():
+				// 用戶端已中斷，關閉後端回應以結束等待中的讀取
+				resp.Body.Close()
+				return
+			case now := <-ticks:
+				sw.flushPending()
+				if keepAlive > 0 {
+					if err := sw.keepAlive(keepAlive, now); err != nil {
+						resp.Body.Close()
+						return
+					}
+				}
+			}
+		}
+	}()
+
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			if writeErr := sw.write(buf[:n]); writeErr != nil {
+				return writeErr
+			}
+		}
+		if err == io.EOF {
+			sw.flushPending()
+			return nil
+		}
+		if err != nil {
+			sw.flushPending()
+			return err
+		}
+	}
+}
+
+// isEventStream 判斷回應是否為 Server-Sent Events
+func isEventStream(header http.Header) bool {
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	return mediaType == "text/event-stream"
+}
+
+// sseCounter 依 SSE 格式逐位元組追蹤目前位置，計算已送出的事件數（含至少一個欄位、以空行結束的區塊）
+type sseCounter struct {
+	events   int64
+	lineLen  int  // 目前這一行已讀取的字元數
+	comment  bool // 目前這一行是否為註解（以 : 開頭）
+	hasField bool // 目前的事件是否已有欄位
+	lastCR   bool // 上一個字元是 \r，用於將 \r\n 視為單一換行
+}
+
+func (s *sseCounter) feed(p []byte) {
+	for _, b := range p {
+		if b == '\n' && s.lastCR {
+			s.lastCR = false
+			continue
+		}
+		s.lastCR = b == '\r'
+		if b == '\n' || b == '\r' {
+			if s.lineLen == 0 {
+				if s.hasField {
+					s.events++
+					s.hasField = false
+				}
+			} else if !s.comment {
+				s.hasField = true
+			}
+			s.lineLen = 0
+			s.comment = false
+			continue
+		}
+		if s.lineLen == 0 && b == ':' {
+			s.comment = true
+		}
+		s.lineLen++
+	}
+}
+
+// atLineStart 判斷目前是否位於行首
+func (s *sseCounter) atLineStart() bool {
+	return s.lineLen == 0
+}
+
+// atEventBoundary 判斷目前是否位於兩個事件之間
+func (s *sseCounter) atEventBoundary() bool {
+	return s.lineLen == 0 && !s.hasField
+}
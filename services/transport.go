@@ -0,0 +1,210 @@
+package services
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+
+	"infra-manager/models"
+)
+
+// serviceClient 是單一服務共用的 http.Client 與其連線池
+type serviceClient struct {
+	baseURL   string
+	upstreams []models.Upstream
+	timeouts  models.Timeouts
+	settings  models.Transport
+	tls       models.UpstreamTLS
+	targets   []string
+	transport *http.Transport
+	h2c       *http.Transport // 啟用 h2c 時，http 目標的一般請求改用此 Transport
+	client    *http.Client
+}
+
+// transports 以服務 ID 保存 http.Client，讓同一服務的請求重複使用 keep-alive 連線。
+// 服務的後端目標、逾時、連線池或 TLS 設定變更時重建，並關閉舊連線池的閒置連線。
+var transports = struct {
+	sync.Mutex
+	byService map[uint]*serviceClient
+}{byService: make(map[uint]*serviceClient)}
+
+// TransportStats 是服務連線池的設定
+type TransportStats struct {
+	ServiceID           uint     `json:"service_id"`
+	Targets             []string `json:"targets"`
+	MaxIdleConns        int      `json:"max_idle_conns"`
+	MaxIdleConnsPerHost int      `json:"max_idle_conns_per_host"`
+	MaxConnsPerHost     int      `json:"max_conns_per_host"`
+	IdleConnTimeout     string   `json:"idle_conn_timeout"`
+	HTTP2               bool     `json:"http2"`
+	H2C                 bool     `json:"h2c"`
+	CustomCA            bool     `json:"custom_ca"`
+	ClientCert          bool     `json:"client_cert"`
+	InsecureSkipVerify  bool     `json:"insecure_skip_verify"`
+}
+
+// matches 判斷連線池是否仍符合服務目前的設定
+func (e *serviceClient) matches(service models.Service) bool {
+	return sameTargets(e.baseURL, e.upstreams, service) &&
+		e.timeouts == service.Timeouts &&
+		e.settings == service.Transport &&
+		e.tls == tlsIdentity(service.TLS)
+}
+
+// clientFor 回傳服務的 http.Client，設定變更時重建。無法載入 TLS 設定時回傳 errTLSUnavailable
+func clientFor(service models.Service) (*http.Client, error) {
+	transports.Lock()
+	defer transports.Unlock()
+
+	if entry, ok := transports.byService[service.ID]; ok {
+		if entry.matches(service) {
+			return entry.client, nil
+		}
+		entry.closeIdleConnections()
+		delete(transports.byService, service.ID)
+	}
+
+	transport, err := newTransport(service)
+	if err != nil {
+		fmt.Printf("服務 %d 的後端 TLS 設定載入失敗: %v\n", service.ID, err)
+		return nil, errTLSUnavailable
+	}
+	entry := &serviceClient{
+		baseURL:   service.BaseURL,
+		upstreams: slices.Clone(service.Upstreams),
+		timeouts:  service.Timeouts,
+		settings:  service.Transport,
+		tls:       tlsIdentity(service.TLS),
+		transport: transport,
+	}
+	var roundTripper http.RoundTripper = transport
+	if service.Transport.H2C {
+		entry.h2c = newH2CTransport(transport)
+		roundTripper = &h2cRoundTripper{h2c: entry.h2c, fallback: transport}
+	}
+	// 不跟隨後端的重新導向：跨主機重新導向時 Go 只移除 Authorization 與 Cookie，
+	// 以其他標頭或查詢參數注入的後端憑證會被送往 Location 指定的主機。3xx 回應原樣交給用戶端
+	entry.client = &http.Client{Transport: roundTripper, CheckRedirect: noFollowRedirect}
+	for _, target := range service.Targets() {
+		entry.targets = append(entry.targets, target.URL)
+	}
+	transports.byService[service.ID] = entry
+	return entry.client, nil
+}
+
+// noFollowRedirect 讓 http.Client 回傳重新導向回應本身，不跟隨重新導向，避免注入的後端憑證被送往其他主機
+func noFollowRedirect(*http.Request, []*http.Request) error {
+	return http.ErrUseLastResponse
+}
+
+// newTransport 依服務的逾時、連線池與 TLS 設定建立 Transport
+func newTransport(service models.Service) (*http.Transport, error) {
+	tlsConfig, err := tlsConfigFor(service)
+	if err != nil {
+		return nil, err
+	}
+
+	settings := service.Transport
+	maxIdle, maxIdlePerHost := settings.IdleConns()
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialUpstream(&net.Dialer{
+		Timeout:   service.Timeouts.Connect(),
+		KeepAlive: settings.KeepAlive(),
+	})
+	transport.Proxy = bypassProxyForUnixSockets(transport.Proxy)
+	transport.TLSHandshakeTimeout = service.Timeouts.Connect()
+	transport.TLSClientConfig = tlsConfig
+	transport.ResponseHeaderTimeout = service.Timeouts.ResponseHeader()
+	transport.MaxIdleConns = maxIdle
+	transport.MaxIdleConnsPerHost = maxIdlePerHost
+	transport.MaxConnsPerHost = settings.MaxConnsPerHost
+	transport.IdleConnTimeout = settings.IdleConnTimeout()
+	transport.ForceAttemptHTTP2 = !settings.DisableHTTP2
+	if settings.DisableHTTP2 {
+		protocols := new(http.Protocols)
+		protocols.SetHTTP1(true)
+		transport.Protocols = protocols
+	}
+	return transport, nil
+}
+
+// newH2CTransport 以服務的 Transport 為基礎，建立只以 HTTP/2 cleartext（prior knowledge，不經 Upgrade）連線 http 目標的 Transport。
+// 只啟用 h2c 時 Transport 不會使用 HTTP/1，因此 https 目標與協定升級請求仍由原本的 Transport 處理
+func newH2CTransport(transport *http.Transport) *http.Transport {
+	h2c := transport.Clone()
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	h2c.Protocols = protocols
+	return h2c
+}
+
+// h2cRoundTripper 將 http 目標（含 unix socket）的一般請求送往 h2c Transport；https 目標以 ALPN 協商 HTTP/2 或使用 HTTP/1，
+// 協定升級請求（如 WebSocket）需要 HTTP/1，兩者都由 fallback 處理
+type h2cRoundTripper struct {
+	h2c      *http.Transport
+	fallback *http.Transport
+}
+
+func (t *h2cRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme == "http" && !isUpgradeRequest(req) {
+		return t.h2c.RoundTrip(req)
+	}
+	return t.fallback.RoundTrip(req)
+}
+
+// closeIdleConnections 關閉服務所有 Transport 的閒置連線
+func (e *serviceClient) closeIdleConnections() {
+	e.transport.CloseIdleConnections()
+	if e.h2c != nil {
+		e.h2c.CloseIdleConnections()
+	}
+}
+
+// InvalidateService 捨棄服務的連線池，下一個請求會以最新設定重建。
+// 服務更新、停用或刪除時呼叫。
+func InvalidateService(serviceID uint) {
+	transports.Lock()
+	entry, ok := transports.byService[serviceID]
+	delete(transports.byService, serviceID)
+	transports.Unlock()
+
+	if ok {
+		entry.closeIdleConnections()
+	}
+
+	// 路由規則變更後不再使用的後端目標
+	removeRouteTargets(serviceID)
+
+	// 路由、後端或身分設定變更後，已快取的回應可能不再正確
+	responseCache.purge(serviceID, "", false)
+}
+
+// GetTransportStats 回傳目前已建立的服務連線池設定，依服務 ID 排序
+func GetTransportStats() []TransportStats {
+	transports.Lock()
+	defer transports.Unlock()
+
+	stats := make([]TransportStats, 0, len(transports.byService))
+	for serviceID, entry := range transports.byService {
+		t := entry.transport
+		stats = append(stats, TransportStats{
+			ServiceID:           serviceID,
+			Targets:             entry.targets,
+			MaxIdleConns:        t.MaxIdleConns,
+			MaxIdleConnsPerHost: t.MaxIdleConnsPerHost,
+			MaxConnsPerHost:     t.MaxConnsPerHost,
+			IdleConnTimeout:     t.IdleConnTimeout.String(),
+			HTTP2:               t.ForceAttemptHTTP2,
+			H2C:                 entry.h2c != nil,
+			CustomCA:            t.TLSClientConfig != nil && t.TLSClientConfig.RootCAs != nil,
+			ClientCert:          t.TLSClientConfig != nil && len(t.TLSClientConfig.Certificates) > 0,
+			InsecureSkipVerify:  t.TLSClientConfig != nil && t.TLSClientConfig.InsecureSkipVerify,
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].ServiceID < stats[j].ServiceID })
+	return stats
+}
@@ -0,0 +1,146 @@
+package services
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// BenchmarkProxyRequestClient 比較服務共用的連線池與每個請求建立新的 http.Client（使用預設 Transport）。
+// 以 16 倍 GOMAXPROCS 的並行請求經由 ProxyRequest 轉發到 httptest 後端，另外回報每個請求新建的後端連線數。
+func BenchmarkProxyRequestClient(b *testing.B) {
+	payload := strings.Repeat("x", 4096)
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, payload)
+	}))
+	conns := countConnections(upstream)
+	upstream.Start()
+	defer upstream.Close()
+
+	run := func(b *testing.B, freshClient bool) {
+		service := newTestService(upstream.URL)
+		proxy := newTestProxy(b, service)
+		if freshClient {
+			// 先建立服務的連線池項目，再於每個請求前換成新的 http.Client，與共用連線池前的做法相同
+			if _, err := clientFor(service); err != nil {
+				b.Fatal(err)
+			}
+		}
+		client := &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: 64}}
+		defer client.CloseIdleConnections()
+
+		conns.Store(0)
+		// 並行數超過預設 Transport 每個主機保留的 2 條閒置連線，才能看出連線池的差異
+		b.SetParallelism(16)
+		b.ReportAllocs()
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if freshClient {
+					transports.Lock()
+					transports.byService[service.ID].client = &http.Client{}
+					transports.Unlock()
+				}
+				resp, err := client.Get(proxy.URL + "/use/test/items")
+				if err != nil {
+					b.Error(err)
+					return
+				}
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusOK {
+					b.Errorf("狀態碼 %d，預期 200", resp.StatusCode)
+					return
+				}
+			}
+		})
+		b.StopTimer()
+		b.ReportMetric(float64(conns.Load())/float64(b.N), "upstream-conns/op")
+	}
+
+	b.Run("pooled", func(b *testing.B) { run(b, false) })
+	b.Run("fresh_client", func(b *testing.B) { run(b, true) })
+}
+
+// 啟用 h2c 的服務：http 目標的一般請求使用 HTTP/2 cleartext，協定升級請求與不支援 HTTP/2 的 https 目標仍以 HTTP/1 轉發
+func TestH2CServiceKeepsHTTP1(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") == "" {
+			io.WriteString(w, r.Proto)
+			return
+		}
+		conn, buf, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		buf.Flush()
+		line, _ := buf.ReadString('\n')
+		io.WriteString(conn, line)
+	})
+
+	plain := httptest.NewUnstartedServer(handler)
+	plain.Config.Protocols = new(http.Protocols)
+	plain.Config.Protocols.SetHTTP1(true)
+	plain.Config.Protocols.SetUnencryptedHTTP2(true)
+	plain.Start()
+	defer plain.Close()
+
+	service := newTestService(plain.URL)
+	service.Transport.H2C = true
+	proxy := newTestProxy(t, service)
+
+	resp, err := http.Get(proxy.URL + "/use/test/items")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "HTTP/2.0" {
+		t.Errorf("h2c 服務的一般請求應以 HTTP/2 轉發，後端收到 %q", body)
+	}
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(proxy.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "GET /use/test/echo HTTP/1.1\r\nHost: proxy\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+	reader := bufio.NewReader(conn)
+	upgraded, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upgraded.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("h2c 服務的協定升級請求應回傳 101，得到 %d", upgraded.StatusCode)
+	}
+	io.WriteString(conn, "ping\n")
+	if line, _ := reader.ReadString('\n'); line != "ping\n" {
+		t.Errorf("升級後的連線應雙向轉送，得到 %q", line)
+	}
+
+	// httptest 的 TLS 後端未啟用 HTTP/2，ALPN 只提供 http/1.1
+	secure := httptest.NewTLSServer(handler)
+	defer secure.Close()
+	service = newTestService(secure.URL)
+	service.Transport.H2C = true
+	service.TLS.InsecureSkipVerify = true
+	proxy = newTestProxy(t, service)
+
+	resp, err = http.Get(proxy.URL + "/use/test/items")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "HTTP/1.1" {
+		t.Errorf("h2c 服務的 https 目標應可使用 HTTP/1.1，得到 %d %q", resp.StatusCode, body)
+	}
+}
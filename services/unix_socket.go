@@ -0,0 +1,81 @@
+package services
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// unixSocketHostSuffix 是 unix socket 目標在代理請求 URL 中使用的虛擬主機名稱後綴。
+// 主機名稱為 socket 路徑的十六進位編碼，讓連線池依 socket 區分連線，並由 dialUpstream 解碼後連線。
+const unixSocketHostSuffix = ".unix-socket"
+
+// unixSocketRequestHost 是轉發到 unix socket 目標時送出的 Host 標頭
+const unixSocketRequestHost = "localhost"
+
+// parseUnixSocketURL 解析 unix:///path/to.sock 或 unix:///path/to.sock:/http/path 格式的後端目標，
+// 轉換為以虛擬主機名稱表示的 http URL。socket 路徑本身可以包含 :（例如 /run/app:v2.sock），
+// 因此以最後一個 :/ 分隔 socket 路徑與 HTTP 路徑
+func parseUnixSocketURL(raw string) (*url.URL, error) {
+	socketPath, httpPath := strings.TrimPrefix(raw, "unix://"), ""
+	if i := strings.LastIndex(socketPath, ":/"); i >= 0 {
+		socketPath, httpPath = socketPath[:i], socketPath[i+1:]
+	}
+	if !strings.HasPrefix(socketPath, "/") || socketPath == "/" {
+		return nil, fmt.Errorf("無效的後端目標URL %q，unix socket 格式為 unix:///path/to.sock 或 unix:///path/to.sock:/http/path", raw)
+	}
+	return &url.URL{
+		Scheme: "http",
+		Host:   hex.EncodeToString([]byte(socketPath)) + unixSocketHostSuffix,
+		Path:   httpPath,
+	}, nil
+}
+
+// unixSocketPath 從虛擬主機名稱（可含埠號）取回 socket 路徑
+func unixSocketPath(host string) (string, bool) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	encoded, ok := strings.CutSuffix(host, unixSocketHostSuffix)
+	if !ok {
+		return "", false
+	}
+	socketPath, err := hex.DecodeString(encoded)
+	if err != nil {
+		return "", false
+	}
+	return string(socketPath), true
+}
+
+// isUnixSocketURL 判斷代理請求的 URL 是否指向 unix socket 目標
+func isUnixSocketURL(u *url.URL) bool {
+	_, ok := unixSocketPath(u.Host)
+	return ok
+}
+
+// dialUpstream 連線到後端目標，unix socket 目標改為連線到對應的 socket 檔案
+func dialUpstream(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if socketPath, ok := unixSocketPath(addr); ok {
+			return dialer.DialContext(ctx, "unix", socketPath)
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}
+}
+
+// bypassProxyForUnixSockets 讓 unix socket 目標不經過 HTTP_PROXY 等環境變數設定的代理
+func bypassProxyForUnixSockets(proxy func(*http.Request) (*url.URL, error)) func(*http.Request) (*url.URL, error) {
+	if proxy == nil {
+		return nil
+	}
+	return func(req *http.Request) (*url.URL, error) {
+		if isUnixSocketURL(req.URL) {
+			return nil, nil
+		}
+		return proxy(req)
+	}
+}
@@ -0,0 +1,136 @@
+package services
+
+import (
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"infra-manager/models"
+)
+
+func TestParseUpstreamURLUnixSocket(t *testing.T) {
+	tests := []struct {
+		raw      string
+		socket   string
+		path     string
+		hasError bool
+	}{
+		{raw: "unix:///run/app.sock", socket: "/run/app.sock"},
+		{raw: "unix:///run/app.sock:/api/v1", socket: "/run/app.sock", path: "/api/v1"},
+		{raw: "unix:///run/app:v2.sock", socket: "/run/app:v2.sock"},
+		{raw: "unix:///run/app:v2.sock:/api/v1", socket: "/run/app:v2.sock", path: "/api/v1"},
+		{raw: "unix:///run/app.sock:api", socket: "/run/app.sock:api"},
+		{raw: "unix://run/app.sock", hasError: true},
+		{raw: "unix://:/api", hasError: true},
+		{raw: "unix://", hasError: true},
+	}
+	for _, tt := range tests {
+		u, err := parseUpstreamURL(tt.raw)
+		if tt.hasError {
+			if err == nil {
+				t.Errorf("parseUpstreamURL(%q) 應回傳錯誤", tt.raw)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseUpstreamURL(%q) 回傳錯誤: %v", tt.raw, err)
+			continue
+		}
+		socketPath, ok := unixSocketPath(u.Host)
+		if !ok || socketPath != tt.socket || u.Path != tt.path || u.Scheme != "http" {
+			t.Errorf("parseUpstreamURL(%q) = %s（socket %q），預期 socket %q、路徑 %q", tt.raw, u, socketPath, tt.socket, tt.path)
+		}
+	}
+
+	// 一般主機名稱與無法解碼的虛擬主機名稱不視為 unix socket
+	for _, host := range []string{"example.com:80", "zz" + unixSocketHostSuffix, hex.EncodeToString([]byte("/a.sock"))} {
+		if _, ok := unixSocketPath(host); ok {
+			t.Errorf("%q 不應視為 unix socket 目標", host)
+		}
+	}
+}
+
+// listenUnixSocket 在暫存目錄的 unix socket 上啟動 httptest 後端
+func listenUnixSocket(t *testing.T, handler http.Handler) string {
+	// unix socket 路徑長度有限制（約 108 bytes），不使用較長的 t.TempDir()
+	dir, err := os.MkdirTemp("", "sock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	socketPath := filepath.Join(dir, "app.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream := httptest.NewUnstartedServer(handler)
+	upstream.Listener = listener
+	upstream.Start()
+	t.Cleanup(upstream.Close)
+	return socketPath
+}
+
+func TestProxyRequestUnixSocket(t *testing.T) {
+	type received struct {
+		host, path, query string
+	}
+	requests := make(chan received, 1)
+	socketPath := listenUnixSocket(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- received{host: r.Host, path: r.URL.Path, query: r.URL.RawQuery}
+		io.WriteString(w, "from socket")
+	}))
+
+	tests := []struct {
+		name     string
+		service  func(models.Service) models.Service
+		endpoint string
+		wantPath string
+	}{
+		{
+			name:     "後端目標為 socket",
+			service:  func(s models.Service) models.Service { return s },
+			endpoint: "/use/test/items?page=2",
+			wantPath: "/api/items",
+		},
+		{
+			name: "路由規則指定 socket",
+			service: func(s models.Service) models.Service {
+				s.BaseURL = "http://127.0.0.1:1"
+				s.Routes = []models.RouteRule{{Match: models.RouteMatchPrefix, Pattern: "/v2", Rewrite: "/", Upstream: "unix://" + socketPath + ":/internal"}}
+				return s
+			},
+			endpoint: "/use/test/v2/items?page=2",
+			wantPath: "/internal/items",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := tt.service(newTestService("unix://" + socketPath + ":/api"))
+			proxy := newTestProxy(t, service)
+
+			resp, err := http.Get(proxy.URL + tt.endpoint)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK || string(body) != "from socket" {
+				t.Fatalf("得到 %d %q，預期由 socket 後端回應", resp.StatusCode, body)
+			}
+
+			got := <-requests
+			if got.host != unixSocketRequestHost {
+				t.Errorf("後端收到的 Host 為 %q，預期 %q", got.host, unixSocketRequestHost)
+			}
+			if got.path != tt.wantPath || got.query != "page=2" {
+				t.Errorf("後端收到的路徑為 %s?%s，預期 %s?page=2", got.path, got.query, tt.wantPath)
+			}
+		})
+	}
+}
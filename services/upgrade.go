@@ -0,0 +1,116 @@
+package services
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"infra-manager/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// isUpgradeRequest 判斷請求是否要求協定升級（Connection: Upgrade，例如 WebSocket）
+func isUpgradeRequest(r *http.Request) bool {
+	return headerHasToken(r.Header, "Connection", "upgrade") && r.Header.Get("Upgrade") != ""
+}
+
+// headerHasToken 判斷以逗號分隔的標頭值中是否包含指定的 token（不分大小寫）
+func headerHasToken(header http.Header, key, token string) bool {
+	for _, value := range header.Values(key) {
+		for _, item := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(item), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// proxyUpgrade 轉發協定升級請求。後端回應 101 後，接管（hijack）用戶端連線，
+// 並在兩條連線之間雙向轉送資料，直到任一方關閉。
+// 連線結束後會將升級後的協定與雙向傳輸量寫入上下文，供 Logger 記錄：
+//   - upgradeProtocol：Upgrade 標頭的值，例如 websocket
+//   - tunnelBytesIn：用戶端送往後端的位元組數
+//   - tunnelBytesOut：後端送往用戶端的位元組數
+func proxyUpgrade(c *gin.Context, service models.Service, client *http.Client, proxyReq *http.Request) {
+	// 逐跳標頭已在建立代理請求時移除，協定升級需要重新帶上 Connection 與 Upgrade
+	proxyReq.Header.Set("Connection", "Upgrade")
+	proxyReq.Header.Set("Upgrade", c.Request.Header.Get("Upgrade"))
+
+	proxyResp, err := client.Do(proxyReq)
+	if err != nil {
+		respondUpstreamError(c, err)
+		return
+	}
+
+	// 後端拒絕升級（例如 401 或重新導向）時，與一般請求相同地轉發回應
+	if proxyResp.StatusCode != http.StatusSwitchingProtocols {
+		defer proxyResp.Body.Close()
+		writeProxyResponse(c, service, proxyResp)
+		return
+	}
+
+	backendConn, ok := proxyResp.Body.(io.ReadWriteCloser)
+	if !ok {
+		proxyResp.Body.Close()
+		c.JSON(http.StatusBadGateway, gin.H{"error": "後端連線不支援協定升級"})
+		return
+	}
+	defer backendConn.Close()
+
+	// 先記錄狀態碼，hijack 之後 gin 不會再寫入回應
+	c.Writer.WriteHeader(http.StatusSwitchingProtocols)
+	clientConn, clientBuf, err := c.Writer.Hijack()
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	defer clientConn.Close()
+
+	if err := writeSwitchingProtocols(clientBuf.Writer, proxyResp); err != nil {
+		c.Error(err)
+		return
+	}
+
+	var bytesIn, bytesOut atomic.Int64
+	errc := make(chan error, 2)
+	go func() {
+		// clientBuf.Reader 可能已緩衝用戶端在升級後立即送出的資料
+		n, err := io.Copy(backendConn, clientBuf.Reader)
+		bytesIn.Add(n)
+		errc <- err
+	}()
+	go func() {
+		n, err := io.Copy(clientConn, backendConn)
+		bytesOut.Add(n)
+		errc <- err
+	}()
+
+	// 任一方向結束即關閉兩端，讓另一方向的複製也隨之結束
+	<-errc
+	clientConn.Close()
+	backendConn.Close()
+	<-errc
+
+	c.Set("upgradeProtocol", strings.ToLower(proxyResp.Header.Get("Upgrade")))
+	c.Set("tunnelBytesIn", bytesIn.Load())
+	c.Set("tunnelBytesOut", bytesOut.Load())
+}
+
+// writeSwitchingProtocols 將後端的 101 回應（含 Sec-WebSocket-Accept 等標頭）寫回用戶端
+func writeSwitchingProtocols(w *bufio.Writer, resp *http.Response) error {
+	if _, err := fmt.Fprintf(w, "HTTP/1.1 %d %s\r\n", resp.StatusCode, http.StatusText(resp.StatusCode)); err != nil {
+		return err
+	}
+	if err := resp.Header.Write(w); err != nil {
+		return err
+	}
+	if _, err := w.WriteString("\r\n"); err != nil {
+		return err
+	}
+	return w.Flush()
+}
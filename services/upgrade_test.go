@@ -0,0 +1,159 @@
+package services
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"infra-manager/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 後端拒絕協定升級時，回應與一般請求相同：網頁應用模式改寫 Location 與 cookie Path，並加上 X-Robots-Tag
+func TestRefusedUpgradeUsesProxyResponse(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "/login")
+		w.Header().Set("Set-Cookie", "session=1; Path=/; Domain=backend.internal")
+		w.WriteHeader(http.StatusFound)
+	}))
+	defer upstream.Close()
+
+	service := newTestService(upstream.URL)
+	service.WebApp = true
+	proxy := newTestProxy(t, service)
+
+	req, _ := http.NewRequest(http.MethodGet, proxy.URL+"/use/test/ws", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	client := &http.Client{CheckRedirect: noFollowRedirect}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("應轉發後端的 302，得到 %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Location"); got != "/use/test/login" {
+		t.Errorf("Location 為 %q，預期改寫為 /use/test/login", got)
+	}
+	if got := resp.Header.Get("Set-Cookie"); !strings.Contains(got, "Path=/use/test/") || strings.Contains(got, "Domain") {
+		t.Errorf("Set-Cookie 為 %q，預期移除 Domain 並改寫 Path", got)
+	}
+	if resp.Header.Get("X-Robots-Tag") == "" {
+		t.Error("拒絕升級的回應應加上 X-Robots-Tag")
+	}
+}
+
+// 後端回應 101 後雙向轉送資料；用戶端半關閉連線時通道結束，並記錄升級的協定與雙向的位元組數
+func TestUpgradeTunnelRoundTrip(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "echo" || !headerHasToken(r.Header, "Connection", "upgrade") {
+			http.Error(w, "需要協定升級", http.StatusBadRequest)
+			return
+		}
+		conn, buf, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		buf.Flush()
+		// 逐行回送，並加上前綴讓兩個方向的位元組數不同
+		for {
+			line, err := buf.ReadString('\n')
+			if err != nil {
+				return
+			}
+			buf.WriteString("echo: " + line)
+			buf.Flush()
+		}
+	}))
+	defer upstream.Close()
+
+	type tunnelStats struct {
+		protocol string
+		in, out  int64
+		status   int
+	}
+	done := make(chan tunnelStats, 1)
+	service := newTestService(upstream.URL)
+	engine := gin.New()
+	engine.Any("/use/test/*endpoint", func(c *gin.Context) {
+		c.Set("service", service)
+		c.Set("token", models.Token{ID: 1, UserID: 1, ServiceID: service.ID})
+		c.Set("user", models.User{ID: 1, Username: "tester"})
+		c.Set("targetEndpoint", strings.TrimPrefix(c.Param("endpoint"), "/"))
+		c.Set("proxyPrefix", "/use/test")
+		c.Next()
+		done <- tunnelStats{
+			protocol: c.GetString("upgradeProtocol"),
+			in:       c.GetInt64("tunnelBytesIn"),
+			out:      c.GetInt64("tunnelBytesOut"),
+			status:   c.Writer.Status(),
+		}
+	}, ProxyRequest)
+	proxy := httptest.NewServer(engine)
+	defer proxy.Close()
+	defer InvalidateService(service.ID)
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(proxy.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, "GET /use/test/tunnel HTTP/1.1\r\nHost: proxy\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Upgrade") != "echo" {
+		t.Fatalf("應回傳後端的 101 與 Upgrade 標頭，得到 %d %v", resp.StatusCode, resp.Header)
+	}
+
+	messages := []string{"hello\n", "second message\n"}
+	var sent, received int64
+	for _, message := range messages {
+		io.WriteString(conn, message)
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line != "echo: "+message {
+			t.Errorf("收到 %q，預期 %q", line, "echo: "+message)
+		}
+		sent += int64(len(message))
+		received += int64(len(line))
+	}
+	// 用戶端半關閉（只關閉寫入端）後，代理應結束通道並關閉兩端，用戶端讀到 EOF
+	if err := conn.(*net.TCPConn).CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	if rest, err := io.ReadAll(reader); err != nil || len(rest) != 0 {
+		t.Errorf("半關閉後應讀到 EOF，得到 %q %v", rest, err)
+	}
+
+	select {
+	case stats := <-done:
+		if stats.status != http.StatusSwitchingProtocols || stats.protocol != "echo" {
+			t.Errorf("存取紀錄的狀態與協定為 %d %q，預期 101 echo", stats.status, stats.protocol)
+		}
+		if stats.in != sent || stats.out != received {
+			t.Errorf("通道位元組數為 in=%d out=%d，預期 in=%d out=%d", stats.in, stats.out, sent, received)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("用戶端半關閉連線後通道未結束")
+	}
+}
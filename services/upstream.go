@@ -0,0 +1,301 @@
+package services
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"infra-manager/models"
+)
+
+// upstreamTarget 是單一後端目標的執行狀態
+type upstreamTarget struct {
+	raw    string
+	url    *url.URL
+	weight int
+
+	healthy atomic.Bool
+	active  atomic.Int64 // 進行中的請求數
+
+	// mu 保護健康檢查的計數與結果
+	mu          sync.Mutex
+	successes   int
+	failures    int
+	lastChecked time.Time
+	lastError   string
+
+	// currentWeight 供平滑加權輪詢使用，由 upstreamPool.mu 保護
+	currentWeight int
+}
+
+// upstreamPool 是單一服務目前設定下的後端目標集合
+type upstreamPool struct {
+	baseURL     string
+	upstreams   []models.Upstream
+	strategy    string
+	healthCheck models.HealthCheck
+	targets     []*upstreamTarget
+
+	mu        sync.Mutex // 保護輪詢狀態
+	checking  atomic.Bool
+	nextCheck atomic.Int64 // 下次健康檢查時間（UnixNano）
+}
+
+// upstreamRegistry 以服務 ID 保存後端目標集合，服務設定變更時重建，並保留相同 URL 目標的健康狀態
+type upstreamRegistry struct {
+	mu    sync.Mutex
+	pools map[uint]*upstreamPool
+}
+
+var upstreams = &upstreamRegistry{pools: make(map[uint]*upstreamPool)}
+
+// UpstreamHealth 是後端目標的健康狀態
+type UpstreamHealth struct {
+	URL                  string     `json:"url"`
+	Weight               int        `json:"weight"`
+	Healthy              bool       `json:"healthy"`
+	ActiveRequests       int64      `json:"active_requests"`
+	ConsecutiveSuccesses int        `json:"consecutive_successes"`
+	ConsecutiveFailures  int        `json:"consecutive_failures"`
+	LastCheckedAt        *time.Time `json:"last_checked_at"`
+	LastError            string     `json:"last_error,omitempty"`
+}
+
+// ValidateUpstreams 檢查負載平衡方式與後端目標設定
+func ValidateUpstreams(strategy string, targets []models.Upstream) error {
+	switch strategy {
+	case "", models.LoadBalancingRoundRobin, models.LoadBalancingLeastConn, models.LoadBalancingTokenHash:
+	default:
+		return fmt.Errorf("無效的負載平衡方式 %q", strategy)
+	}
+	for _, target := range targets {
+		if _, err := parseUpstreamURL(target.URL); err != nil {
+			return err
+		}
+		if target.Weight < 0 {
+			return fmt.Errorf("後端目標 %q 的權重不可為負數", target.URL)
+		}
+	}
+	return nil
+}
+
+// parseUpstreamURL 解析後端目標 URL，支援 http、https 與 unix socket（unix:///path/to.sock:/http/path）
+func parseUpstreamURL(raw string) (*url.URL, error) {
+	if strings.HasPrefix(raw, "unix://") {
+		return parseUnixSocketURL(raw)
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("無效的後端目標URL %q", raw)
+	}
+	return u, nil
+}
+
+// sameTargets 判斷服務的後端目標設定是否與快取時相同
+func sameTargets(baseURL string, upstreams []models.Upstream, service models.Service) bool {
+	return baseURL == service.BaseURL && slices.Equal(upstreams, service.Upstreams)
+}
+
+// matches 判斷集合是否仍符合服務目前的設定
+func (p *upstreamPool) matches(service models.Service) bool {
+	return sameTargets(p.baseURL, p.upstreams, service) &&
+		p.strategy == service.LoadBalancing &&
+		p.healthCheck == service.HealthCheck
+}
+
+// pool 取得服務目前設定對應的後端目標集合
+func (r *upstreamRegistry) pool(service models.Service) (*upstreamPool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	old := r.pools[service.ID]
+	if old != nil && old.matches(service) {
+		return old, nil
+	}
+
+	pool := &upstreamPool{
+		baseURL:     service.BaseURL,
+		upstreams:   slices.Clone(service.Upstreams),
+		strategy:    service.LoadBalancing,
+		healthCheck: service.HealthCheck,
+	}
+	for _, t := range service.Targets() {
+		u, err := parseUpstreamURL(t.URL)
+		if err != nil {
+			return nil, err
+		}
+		target := &upstreamTarget{raw: t.URL, url: u, weight: t.Weight}
+		target.healthy.Store(true)
+		if old != nil {
+			target.inherit(old.find(t.URL))
+		}
+		pool.targets = append(pool.targets, target)
+	}
+	if old != nil {
+		pool.nextCheck.Store(old.nextCheck.Load())
+	}
+	r.pools[service.ID] = pool
+	return pool, nil
+}
+
+// prune 移除不在 keep 中的服務
+func (r *upstreamRegistry) prune(keep map[uint]bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id := range r.pools {
+		if !keep[id] {
+			delete(r.pools, id)
+		}
+	}
+}
+
+func (p *upstreamPool) find(raw string) *upstreamTarget {
+	for _, target := range p.targets {
+		if target.raw == raw {
+			return target
+		}
+	}
+	return nil
+}
+
+// inherit 沿用舊目標的健康狀態
+func (t *upstreamTarget) inherit(old *upstreamTarget) {
+	if old == nil {
+		return
+	}
+	old.mu.Lock()
+	defer old.mu.Unlock()
+	t.healthy.Store(old.healthy.Load())
+	t.successes = old.successes
+	t.failures = old.failures
+	t.lastChecked = old.lastChecked
+	t.lastError = old.lastError
+}
+
+// pick 依負載平衡方式選出一個後端目標。
+// 只會選擇健康的目標；若全部不健康，為避免健康檢查設定錯誤造成整個服務中斷，改從所有目標中選擇。
+func (p *upstreamPool) pick(tokenID uint) *upstreamTarget {
+	candidates := p.targets
+	if p.healthCheck.Enabled() {
+		healthy := make([]*upstreamTarget, 0, len(p.targets))
+		for _, target := range p.targets {
+			if target.healthy.Load() {
+				healthy = append(healthy, target)
+			}
+		}
+		if len(healthy) > 0 {
+			candidates = healthy
+		}
+	}
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+
+	switch p.strategy {
+	case models.LoadBalancingLeastConn:
+		return pickLeastConn(candidates)
+	case models.LoadBalancingTokenHash:
+		return pickRendezvous(candidates, strconv.FormatUint(uint64(tokenID), 10))
+	default:
+		return p.pickRoundRobin(candidates)
+	}
+}
+
+// pickRoundRobin 以平滑加權輪詢（與 nginx 相同的演算法）選擇目標，權重高者被選中的次數較多但分散
+func (p *upstreamPool) pickRoundRobin(candidates []*upstreamTarget) *upstreamTarget {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var best *upstreamTarget
+	total := 0
+	for _, target := range candidates {
+		target.currentWeight += target.weight
+		total += target.weight
+		if best == nil || target.currentWeight > best.currentWeight {
+			best = target
+		}
+	}
+	best.currentWeight -= total
+	return best
+}
+
+// pickLeastConn 選擇進行中請求數相對權重最少的目標
+func pickLeastConn(candidates []*upstreamTarget) *upstreamTarget {
+	var best *upstreamTarget
+	bestLoad := math.Inf(1)
+	for _, target := range candidates {
+		load := float64(target.active.Load()) / float64(target.weight)
+		if load < bestLoad {
+			best, bestLoad = target, load
+		}
+	}
+	return best
+}
+
+// pickRendezvous 以加權 rendezvous hashing 選擇目標：同一個 key 固定對應同一目標，
+// 目標增減時只有原本對應到該目標的 key 會被重新分配
+func pickRendezvous(candidates []*upstreamTarget, key string) *upstreamTarget {
+	var best *upstreamTarget
+	bestScore := math.Inf(-1)
+	for _, target := range candidates {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		h.Write([]byte{0})
+		h.Write([]byte(target.raw))
+		// 將雜湊值映射到 (0, 1)，再依權重計算分數
+		u := (float64(h.Sum64()>>11) + 0.5) / (1 << 53)
+		score := float64(target.weight) / -math.Log(u)
+		if score > bestScore {
+			best, bestScore = target, score
+		}
+	}
+	return best
+}
+
+// pickUpstream 為請求選擇服務的後端目標
+func pickUpstream(service models.Service, tokenID uint) (*upstreamTarget, error) {
+	pool, err := upstreams.pool(service)
+	if err != nil {
+		return nil, err
+	}
+	if len(pool.targets) == 0 {
+		return nil, fmt.Errorf("服務沒有可用的後端目標")
+	}
+	return pool.pick(tokenID), nil
+}
+
+// GetUpstreamHealth 回傳服務各後端目標的健康狀態與進行中請求數
+func GetUpstreamHealth(service models.Service) ([]UpstreamHealth, error) {
+	pool, err := upstreams.pool(service)
+	if err != nil {
+		return nil, err
+	}
+
+	health := make([]UpstreamHealth, 0, len(pool.targets))
+	for _, target := range pool.targets {
+		target.mu.Lock()
+		item := UpstreamHealth{
+			URL:                  target.raw,
+			Weight:               target.weight,
+			Healthy:              target.healthy.Load(),
+			ActiveRequests:       target.active.Load(),
+			ConsecutiveSuccesses: target.successes,
+			ConsecutiveFailures:  target.failures,
+			LastError:            target.lastError,
+		}
+		if !target.lastChecked.IsZero() {
+			checked := target.lastChecked
+			item.LastCheckedAt = &checked
+		}
+		target.mu.Unlock()
+		health = append(health, item)
+	}
+	return health, nil
+}
@@ -0,0 +1,122 @@
+package services
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+
+	"infra-manager/db"
+	"infra-manager/models"
+	"infra-manager/secrets"
+)
+
+// errTLSUnavailable 表示無法載入服務的 TLS 憑證（例如金鑰未設定或已變更），
+// 此時不應改用預設的 TLS 設定連線
+var errTLSUnavailable = errors.New("無法載入後端 TLS 設定")
+
+// tlsConfigFor 依服務的 TLS 設定（自訂 CA、用戶端憑證、SNI 與最低 TLS 版本）建立 tls.Config，
+// 未設定時回傳 nil（使用預設設定）。CA 與用戶端憑證以 SERVICE_SECRET_KEY 加密保存，使用時解密。
+func tlsConfigFor(service models.Service) (*tls.Config, error) {
+	settings := service.TLS
+	if !settings.Configured() {
+		return nil, nil
+	}
+
+	config := &tls.Config{
+		ServerName:         settings.ServerName,
+		MinVersion:         settings.Version(),
+		InsecureSkipVerify: settings.InsecureSkipVerify,
+	}
+	if settings.InsecureSkipVerify {
+		fmt.Printf("警告: 服務 %d（%s）已停用後端 TLS 憑證驗證（insecure_skip_verify），僅應用於開發環境\n", service.ID, service.Name)
+	}
+
+	if settings.CABundle != "" {
+		bundle, err := decryptTLSMaterial(service.ID, models.TLSMaterialCA, settings.CABundle)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(bundle)) {
+			return nil, errors.New("CA 憑證中沒有有效的 PEM 憑證")
+		}
+		config.RootCAs = pool
+	}
+
+	if settings.ClientCert != "" {
+		certPEM, err := decryptTLSMaterial(service.ID, models.TLSMaterialClientCert, settings.ClientCert)
+		if err != nil {
+			return nil, err
+		}
+		keyPEM, err := decryptTLSMaterial(service.ID, models.TLSMaterialClientKey, settings.ClientKey)
+		if err != nil {
+			return nil, err
+		}
+		cert, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+		if err != nil {
+			return nil, fmt.Errorf("無效的用戶端憑證: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+func decryptTLSMaterial(serviceID uint, material, ciphertext string) (string, error) {
+	value, _, err := secrets.DecryptSecret(ciphertext, models.TLSEncryptionContext(serviceID, material))
+	if err != nil {
+		return "", fmt.Errorf("無法解密服務的 TLS 憑證 %s: %w", material, err)
+	}
+	return value, nil
+}
+
+// tlsIdentity 回傳比對連線池設定時使用的 TLS 設定，不含到期時間等僅供顯示的資訊
+func tlsIdentity(settings models.UpstreamTLS) models.UpstreamTLS {
+	settings.CAExpiresAt, settings.ClientCertExpiresAt, settings.ClientCertSubject = nil, nil, ""
+	return settings
+}
+
+// reencryptTLSMaterial 以目前的金鑰重新加密以舊金鑰加密的 TLS 憑證，回傳重新加密的筆數
+func reencryptTLSMaterial() (int, error) {
+	var stored []models.Service
+	if err := db.DB.Where("tls_ca_bundle <> '' OR tls_client_cert <> ''").Find(&stored).Error; err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, service := range stored {
+		materials := map[string]*string{
+			models.TLSMaterialCA:         &service.TLS.CABundle,
+			models.TLSMaterialClientCert: &service.TLS.ClientCert,
+			models.TLSMaterialClientKey:  &service.TLS.ClientKey,
+		}
+		changed := false
+		for material, ciphertext := range materials {
+			if *ciphertext == "" {
+				continue
+			}
+			context := models.TLSEncryptionContext(service.ID, material)
+			value, current, err := secrets.DecryptSecret(*ciphertext, context)
+			if err != nil {
+				return count, fmt.Errorf("無法解密服務 %d 的 TLS 憑證 %s: %w", service.ID, material, err)
+			}
+			if current {
+				continue
+			}
+			if *ciphertext, err = secrets.EncryptSecret(value, context); err != nil {
+				return count, err
+			}
+			changed = true
+			count++
+		}
+		if !changed {
+			continue
+		}
+		err := db.DB.Model(&service).Select("tls_ca_bundle", "tls_client_cert", "tls_client_key").
+			Updates(models.Service{TLS: service.TLS}).Error
+		if err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
@@ -348,7 +348,7 @@ function fillTokenSelectors(tokens) {
         activeTokens.forEach(token => {
             const option = document.createElement('option');
             option.value = token.id;
-            option.textContent = `${token.user?.username || '未知用戶'} - ${token.service?.name || '未知服務'} (${token.token_value?.substring(0, 8)}...)`;
+            option.textContent = `${token.user?.username || '未知用戶'} - ${token.service?.name || '未知服務'} (${token.token_prefix || ''}...)`;
             tokenTimeSelector.appendChild(option);
         });
 
@@ -672,8 +672,7 @@ function renderTokenTable(tokens) {
         const isExpired = expiryDate && !isPermanent && expiryDate < new Date();
         if (isExpired) row.classList.add('token-expired');
 
-        const displayToken = token.token_value || '-';
-        const serviceName = token.service ? token.service.name : '';
+        const displayToken = token.token_prefix ? `${token.token_prefix}...` : '-';
 
         // 狀態邏輯：過期 > 失效(Disabled) > 停用/啟用
         let statusHtml = '';
@@ -695,11 +694,7 @@ function renderTokenTable(tokens) {
         row.innerHTML = `
             <td>${token.id}</td>
             <td class="td-token">
-                <div class="token-text" title="${token.token_value}">${displayToken}</div>
-                <div class="token-actions">
-                    <button class="btn btn-info btn-sm" onclick="copyToken('${token.token_value}')">複製</button>
-                    <button class="btn btn-secondary btn-sm" onclick="copyRequestUrl('${serviceName}', '${token.token_value}')">複製請求網址</button>
-                </div>
+                <div class="token-text" title="完整Token僅在建立時顯示一次">${displayToken}</div>
             </td>
             <td>${token.user ? token.user.username : '未知使用者'}</td>
             <td>${token.service ? token.service.name : '未知服務'}</td>
@@ -885,6 +880,8 @@ function renderServiceTable(services) {
             <td>${service.name}</td>
             <td>${service.description}</td>
             <td>${service.base_url}</td>
+            <td id="serviceHealth-${service.id}">-</td>
+            <td>${formatServiceTLS(service.tls)}</td>
             <td>${service.is_active ? '啟用' : '停用'}</td>
             <td>
                 <button class="btn btn-primary btn-sm" onclick="editService(${service.id})">編輯</button>
@@ -895,9 +892,61 @@ function renderServiceTable(services) {
             </td>
         `;
         tableBody.appendChild(row);
+        fetchServiceHealth(service.id);
     });
 }
 
+// 顯示服務各後端目標的健康狀態
+function fetchServiceHealth(serviceId) {
+    fetchWithAuth(`${API_BASE_URL}/services/${serviceId}/health`)
+        .then(health => {
+            const cell = document.getElementById(`serviceHealth-${serviceId}`);
+            if (!cell || !health || !health.targets) return;
+            cell.innerHTML = health.targets.map(target => {
+                let status = '<span class="text-success">●</span>';
+                if (health.health_check_enabled && !target.healthy) {
+                    status = `<span class="text-danger" title="${target.last_error || ''}">●</span>`;
+                }
+                return `<div>${status} ${target.url} (權重 ${target.weight}，進行中 ${target.active_requests})</div>`;
+            }).join('');
+        })
+        .catch(error => console.error('獲取服務健康狀態失敗:', error));
+}
+
+// 顯示服務 TLS 憑證的到期時間，30 天內到期或已過期時以顏色標示
+function formatServiceTLS(tls) {
+    if (!tls) return '-';
+    const rows = [];
+    const expiry = (label, expiresAt) => {
+        const date = new Date(expiresAt);
+        const days = Math.floor((date - Date.now()) / 86400000);
+        let cls = 'text-success';
+        if (days < 0) cls = 'text-danger';
+        else if (days < 30) cls = 'text-warning';
+        return `<div><span class="${cls}">●</span> ${label} 到期：${date.toLocaleDateString()}${days < 0 ? '（已過期）' : `（${days} 天）`}</div>`;
+    };
+    if (tls.ca_expires_at) rows.push(expiry('CA', tls.ca_expires_at));
+    if (tls.client_cert_expires_at) rows.push(expiry(`用戶端憑證 ${tls.client_cert_subject || ''}`, tls.client_cert_expires_at));
+    if (tls.insecure_skip_verify) rows.push('<div class="text-danger">不驗證憑證</div>');
+    return rows.length ? rows.join('') : '-';
+}
+
+// 將每行「URL 權重」格式的文字轉換為後端目標清單
+function parseUpstreams(text) {
+    return text.split('\n')
+        .map(line => line.trim())
+        .filter(line => line !== '')
+        .map(line => {
+            const parts = line.split(/\s+/);
+            return { url: parts[0], weight: parseInt(parts[1] || '1', 10) || 1 };
+        });
+}
+
+// 將後端目標清單轉換為每行「URL 權重」格式的文字
+function formatUpstreams(upstreams) {
+    return (upstreams || []).map(target => `${target.url} ${target.weight || 1}`).join('\n');
+}
+
 // 渲染最近統計數據
 function renderRecentStats(stats) {
     // 確保有數據
@@ -1337,7 +1386,7 @@ function updateUserTokenChart() {
                         r.data.forEach(item => { map[item.date] = item.count; });
                         const dataArr = dateRange.map(d => map[d] || 0);
                         datasets.push({
-                            label: `${r.token.token_value.substring(0, 8)}... - ${r.token.service?.name || ''}`,
+                            label: `${r.token.token_prefix}... - ${r.token.service?.name || ''}`,
                             data: dataArr,
                             fill: false,
                             borderColor: colors[idx],
@@ -1367,7 +1416,7 @@ function updateUserTokenChart() {
                 data.forEach(item => {
                     if (!tokensMap[item.token_id]) {
                         tokensMap[item.token_id] = {
-                            name: `${item.token_value.substring(0, 8)}... - ${item.service_name}`,
+                            name: `${item.token_prefix}... - ${item.service_name}`,
                             data: {}
                         };
                     }
@@ -1449,7 +1498,7 @@ function updateUserTokenChart() {
     } else {
         // 長條圖/圓餅圖：以token為橫軸
         fetchUserTokenStats(userId).then(data => {
-            const labels = data.map(d => `${d.token_value.substring(0, 8)}... - ${d.service_name}`);
+            const labels = data.map(d => `${d.token_prefix}... - ${d.service_name}`);
             const counts = data.map(d => d.count);
             const colors = getPalette(data.length);
 
@@ -1547,7 +1596,7 @@ function updateTokenTimeChart() {
                     r.data.forEach(item => { map[item.date] = item.count; });
                     const dataArr = dateRange.map(d => map[d] || 0);
                     datasets.push({
-                        label: `${r.token.token_value.substring(0, 8)}... - ${r.token.service?.name || ''}`,
+                        label: `${r.token.token_prefix}... - ${r.token.service?.name || ''}`,
                         data: dataArr,
                         fill: false,
                         borderColor: colors[idx],
@@ -2028,6 +2077,16 @@ function addService() {
     const name = document.getElementById('newServiceName').value;
     const description = document.getElementById('newServiceDescription').value;
     const baseUrl = document.getElementById('newServiceBaseUrl').value;
+    const tokenSources = document.getElementById('newServiceTokenSources').value;
+    const upstreams = parseUpstreams(document.getElementById('newServiceUpstreams').value);
+    const loadBalancing = document.getElementById('newServiceLoadBalancing').value;
+    const healthCheckPath = document.getElementById('newServiceHealthCheckPath').value;
+    const webApp = document.getElementById('newServiceWebApp').checked;
+    const rewriteBody = document.getElementById('newServiceRewriteBody').value;
+    const cache = {
+        enabled: document.getElementById('newServiceCacheEnabled').checked,
+        default_ttl_seconds: parseInt(document.getElementById('newServiceCacheTTL').value, 10) || 0
+    };
 
     fetchWithAuth(`${API_BASE_URL}/services`, {
         method: 'POST',
@@ -2038,6 +2097,13 @@ function addService() {
             name: name,
             description: description,
             base_url: baseUrl,
+            token_sources: tokenSources,
+            upstreams: upstreams,
+            load_balancing: loadBalancing,
+            health_check: { path: healthCheckPath },
+            web_app: webApp,
+            rewrite_body: rewriteBody,
+            cache: cache,
             is_active: true
         })
     })
@@ -2056,6 +2122,19 @@ function editService(id) {
             document.getElementById('editServiceName').value = service.name;
             document.getElementById('editServiceDescription').value = service.description;
             document.getElementById('editServiceBaseUrl').value = service.base_url;
+            document.getElementById('editServiceTokenSources').value = service.token_sources || '';
+            document.getElementById('editServiceUpstreams').value = formatUpstreams(service.upstreams);
+            document.getElementById('editServiceLoadBalancing').value = service.load_balancing || 'round_robin';
+            document.getElementById('editServiceHealthCheckPath').value = (service.health_check && service.health_check.path) || '';
+            document.getElementById('editServiceModal').dataset.healthCheck = JSON.stringify(service.health_check || {});
+            document.getElementById('editServiceWebApp').checked = !!service.web_app;
+            document.getElementById('editServiceRewriteBody').value = service.rewrite_body || '';
+            document.getElementById('editServiceCacheEnabled').checked = !!(service.cache && service.cache.enabled);
+            document.getElementById('editServiceCacheTTL').value = (service.cache && service.cache.default_ttl_seconds) || '';
+            document.getElementById('editServiceModal').dataset.cache = JSON.stringify(service.cache || {});
+            document.getElementById('editServiceTLSServerName').value = (service.tls && service.tls.server_name) || '';
+            document.getElementById('editServiceTLSMinVersion').value = (service.tls && service.tls.min_version) || '';
+            document.getElementById('editServiceTLSInsecure').checked = !!(service.tls && service.tls.insecure_skip_verify);
 
             document.getElementById('editServiceModal').style.display = 'block';
         })
@@ -2068,6 +2147,24 @@ function updateService() {
     const name = document.getElementById('editServiceName').value;
     const description = document.getElementById('editServiceDescription').value;
     const baseUrl = document.getElementById('editServiceBaseUrl').value;
+    const tokenSources = document.getElementById('editServiceTokenSources').value;
+    const upstreams = parseUpstreams(document.getElementById('editServiceUpstreams').value);
+    const loadBalancing = document.getElementById('editServiceLoadBalancing').value;
+    // 保留其他健康檢查設定，只更新表單上的路徑
+    const healthCheck = JSON.parse(document.getElementById('editServiceModal').dataset.healthCheck || '{}');
+    healthCheck.path = document.getElementById('editServiceHealthCheckPath').value;
+    const webApp = document.getElementById('editServiceWebApp').checked;
+    const rewriteBody = document.getElementById('editServiceRewriteBody').value;
+    // 保留快取鍵標頭等其他快取設定
+    const cache = JSON.parse(document.getElementById('editServiceModal').dataset.cache || '{}');
+    cache.enabled = document.getElementById('editServiceCacheEnabled').checked;
+    cache.default_ttl_seconds = parseInt(document.getElementById('editServiceCacheTTL').value, 10) || 0;
+    // 憑證經由上傳 API 設定，這裡只更新連線設定
+    const tls = {
+        server_name: document.getElementById('editServiceTLSServerName').value.trim(),
+        min_version: document.getElementById('editServiceTLSMinVersion').value,
+        insecure_skip_verify: document.getElementById('editServiceTLSInsecure').checked
+    };
 
     fetchWithAuth(`${API_BASE_URL}/services/${id}`, {
         method: 'PUT',
@@ -2078,6 +2175,14 @@ function updateService() {
             name: name,
             description: description,
             base_url: baseUrl,
+            token_sources: tokenSources,
+            upstreams: upstreams,
+            load_balancing: loadBalancing,
+            health_check: healthCheck,
+            web_app: webApp,
+            rewrite_body: rewriteBody,
+            cache: cache,
+            tls: tls,
             is_active: true // 保留原有狀態，不再從表單獲取
         })
     })
@@ -2143,19 +2248,31 @@ function addToken() {
         },
         body: JSON.stringify(requestBody)
     })
-        .then(() => {
+        .then(token => {
             document.getElementById('addTokenModal').style.display = 'none';
+            showCreatedToken(token);
             fetchTokens();
         })
         .catch(error => console.error('添加Token失敗:', error));
 }
 
+// 顯示剛建立的Token，明文Token僅會在建立時回傳這一次
+function showCreatedToken(token) {
+    const serviceName = token.service ? token.service.name : '';
+    document.getElementById('createdTokenValue').value = token.token_value;
+    document.getElementById('createdTokenActions').innerHTML = `
+        <button class="btn btn-info btn-sm" onclick="copyToken('${token.token_value}')">複製</button>
+        <button class="btn btn-secondary btn-sm" onclick="copyRequestUrl('${serviceName}', '${token.token_value}')">複製請求網址</button>
+    `;
+    document.getElementById('createdTokenModal').style.display = 'block';
+}
+
 function editToken(id) {
     // 獲取Token資料並顯示編輯模態窗口
     fetchWithAuth(`${API_BASE_URL}/tokens/${id}`)
         .then(token => {
             document.getElementById('editTokenID').value = token.id;
-            document.getElementById('editTokenValue').value = token.token_value;
+            document.getElementById('editTokenValue').value = `${token.token_prefix}...`;
             document.getElementById('editTokenUser').value = token.user.username;
             document.getElementById('editTokenService').value = token.service.name;
 
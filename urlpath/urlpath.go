@@ -0,0 +1,34 @@
+// Package urlpath 提供Token存取範圍檢查與代理轉發共用的路徑處理，兩者需以相同方式解析路徑
+package urlpath
+
+import "strings"
+
+// NormalizeEndpoint 解析請求端點（不含開頭的 /）中的 . 與 .. 路徑段。
+// Token存取範圍的檢查、路由規則的比對與轉發給後端的路徑都使用此結果，
+// 避免以 secret//../public 這類路徑讓兩者解析出不同的路徑而繞過存取範圍。
+func NormalizeEndpoint(endpoint string) string {
+	return strings.TrimPrefix(CleanDotSegments("/"+endpoint), "/")
+}
+
+// CleanDotSegments 解析路徑中的 . 與 .. 路徑段（不會超出根路徑），其餘內容（結尾斜線、連續斜線）保持不變
+func CleanDotSegments(p string) string {
+	segments := strings.Split(strings.TrimPrefix(p, "/"), "/")
+	cleaned := make([]string, 0, len(segments))
+	for i, segment := range segments {
+		switch segment {
+		case ".":
+		case "..":
+			if len(cleaned) > 0 {
+				cleaned = cleaned[:len(cleaned)-1]
+			}
+		default:
+			cleaned = append(cleaned, segment)
+			continue
+		}
+		// 以 . 或 .. 結尾的路徑代表目錄，保留結尾斜線
+		if i == len(segments)-1 {
+			cleaned = append(cleaned, "")
+		}
+	}
+	return "/" + strings.Join(cleaned, "/")
+}
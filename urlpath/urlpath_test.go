@@ -0,0 +1,22 @@
+package urlpath
+
+import "testing"
+
+func TestNormalizeEndpoint(t *testing.T) {
+	tests := []struct {
+		endpoint, want string
+	}{
+		{"", ""},
+		{"v1/users", "v1/users"},
+		{"v1/users/", "v1/users/"},
+		{"v1//users", "v1//users"},
+		{"v1/./users/..", "v1/"},
+		{"../../etc/passwd", "etc/passwd"},
+		{"v1/secret//../public", "v1/secret/public"},
+	}
+	for _, tt := range tests {
+		if got := NormalizeEndpoint(tt.endpoint); got != tt.want {
+			t.Errorf("NormalizeEndpoint(%q) = %q，預期 %q", tt.endpoint, got, tt.want)
+		}
+	}
+}